@@ -0,0 +1,147 @@
+package main
+
+import (
+	"hash/fnv"
+	"log"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Classe tarifária, escolhida pela ocupação do voo: até MaxLoad (exclusive) dos assentos vendidos
+type FareClass struct {
+	Name       string
+	MaxLoad    float64
+	Multiplier float64
+}
+
+// Acréscimo pela proximidade do voo: vale a primeira faixa com MinDays dias ou mais até o voo
+type AdvanceBand struct {
+	MinDays    int
+	Multiplier float64
+}
+
+var fareClasses = []FareClass{
+	{Name: "PROMO", MaxLoad: 0.3, Multiplier: 0.85},
+	{Name: "ECONOMY", MaxLoad: 0.7, Multiplier: 1},
+	{Name: "FLEX", MaxLoad: 0.9, Multiplier: 1.25},
+	{Name: "LAST_SEATS", MaxLoad: math.Inf(1), Multiplier: 1.5},
+}
+
+var advanceBands = []AdvanceBand{
+	{MinDays: 60, Multiplier: 1},
+	{MinDays: 30, Multiplier: 1.1},
+	{MinDays: 14, Multiplier: 1.25},
+	{MinDays: 7, Multiplier: 1.5},
+	{MinDays: math.MinInt, Multiplier: 1.8},
+}
+
+// Preço de um voo em um instante e a composição dele
+type Fare struct {
+	Value         float64
+	Base          float64
+	Class         string
+	ClassFactor   float64
+	DaysToFlight  int
+	AdvanceFactor float64
+	DailyFactor   float64
+}
+
+// Modelo de tarifas determinístico: o mesmo voo/dia tem sempre o mesmo preço base (sorteado a partir
+// da semente e da chave do voo), que muda com a classe tarifária (ocupação), com a antecedência da compra
+// e com uma variação diária de até DailyVariation, também sorteada pela semente. Duas consultas no mesmo
+// dia com a mesma ocupação retornam o mesmo preço, e a mesma semente reproduz os mesmos preços.
+type FareModel struct {
+	Seed           string
+	MinBase        float64
+	MaxBase        float64
+	DailyVariation float64
+}
+
+// Número em [0, 1) derivado da semente e das partes informadas
+func (m FareModel) random(parts ...string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(m.Seed))
+	for _, part := range parts {
+		h.Write([]byte{0})
+		h.Write([]byte(part))
+	}
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+func (m FareModel) Base(flight, day string) float64 {
+	return m.MinBase + m.random(flight, day)*(m.MaxBase-m.MinBase)
+}
+
+// Quote calcula o preço do voo flight no dia day com seats assentos ocupados, consultado em now
+func (m FareModel) Quote(flight, day string, seats FlightSeats, now time.Time) Fare {
+	fare := Fare{
+		Base:          m.Base(flight, day),
+		AdvanceFactor: 1,
+	}
+
+	load := 1.0
+	if seats.Capacity > 0 {
+		load = float64(seats.Sold) / float64(seats.Capacity)
+	}
+	for _, class := range fareClasses {
+		if load < class.MaxLoad {
+			fare.Class = class.Name
+			fare.ClassFactor = class.Multiplier
+			break
+		}
+	}
+
+	today := now.UTC().Format(time.DateOnly)
+	if flightDay, err := time.Parse(time.DateOnly, day); err == nil {
+		todayDate, _ := time.Parse(time.DateOnly, today)
+		fare.DaysToFlight = int(flightDay.Sub(todayDate).Hours() / 24)
+		for _, band := range advanceBands {
+			if fare.DaysToFlight >= band.MinDays {
+				fare.AdvanceFactor = band.Multiplier
+				break
+			}
+		}
+	}
+
+	fare.DailyFactor = 1 + (2*m.random(flight, day, today)-1)*m.DailyVariation
+
+	value := fare.Base * fare.ClassFactor * fare.AdvanceFactor * fare.DailyFactor
+	fare.Value = math.Round(value*100) / 100
+	return fare
+}
+
+func getEnvFloat(name string, fallback float64) float64 {
+	value := getEnv(name, "")
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Valor inválido para %s (%q), usando padrão %v", name, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func newFareModelFromEnv() FareModel {
+	model := FareModel{
+		Seed:           getEnv("FARE_SEED", "airlineshub"),
+		MinBase:        getEnvFloat("FARE_MIN_BASE", 100),
+		MaxBase:        getEnvFloat("FARE_MAX_BASE", 250),
+		DailyVariation: getEnvFloat("FARE_DAILY_VARIATION", 0.05),
+	}
+	if model.MinBase < 0 || model.MaxBase < model.MinBase {
+		log.Printf("Faixa de preço base inválida (%v a %v), usando 100 a 250", model.MinBase, model.MaxBase)
+		model.MinBase, model.MaxBase = 100, 250
+	}
+	if model.DailyVariation < 0 || model.DailyVariation >= 1 {
+		log.Printf("Valor inválido para FARE_DAILY_VARIATION (%v), usando 0.05", model.DailyVariation)
+		model.DailyVariation = 0.05
+	}
+	log.Printf("Modelo de tarifas: semente %q, preço base entre %.2f e %.2f, variação diária de %.0f%%",
+		model.Seed, model.MinBase, model.MaxBase, model.DailyVariation*100)
+	return model
+}
+
+var fares = newFareModelFromEnv()
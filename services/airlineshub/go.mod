@@ -2,4 +2,9 @@ module github.com/fsousabt/airlineshub
 
 go 1.25.3
 
-require github.com/google/uuid v1.6.0 // indirect
+require (
+	github.com/fsousabt/shared v0.0.0
+	github.com/google/uuid v1.6.0
+)
+
+replace github.com/fsousabt/shared => ../shared
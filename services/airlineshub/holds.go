@@ -0,0 +1,513 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fsousabt/shared/deadline"
+	"github.com/fsousabt/shared/journal"
+	"github.com/google/uuid"
+)
+
+const (
+	HoldStatusHeld      = "HELD"
+	HoldStatusConfirmed = "CONFIRMED"
+	HoldStatusReleased  = "RELEASED"
+	HoldStatusExpired   = "EXPIRED"
+)
+
+var (
+	ErrHoldNotFound = errors.New("reserva de assento não encontrada")
+	ErrHoldExpired  = errors.New("reserva de assento expirada ou liberada")
+	// Idempotency-Key já usado em uma reserva de outro voo/dia
+	ErrHoldKeyMismatch = errors.New("Idempotency-Key já usado em uma reserva de outro voo ou dia")
+	// Idempotency-Key de uma reserva já confirmada (vendida): não vale como uma nova reserva
+	ErrHoldConfirmed = errors.New("reserva com este Idempotency-Key já confirmada")
+)
+
+// Reserva de um assento (hold). O assento sai do estoque na criação e o preço fica travado até ExpiresAt;
+// a venda só acontece na confirmação. Holds não confirmados a tempo são expirados pelo sweeper.
+type Hold struct {
+	ID             string     `json:"id"`
+	Flight         string     `json:"flight"`
+	Day            string     `json:"day"`
+	Value          float64    `json:"value"`
+	FareClass      string     `json:"fareClass"`
+	Status         string     `json:"status"`
+	TransactionID  string     `json:"transactionID,omitempty"` // venda criada na confirmação
+	IdempotencyKey string     `json:"idempotencyKey,omitempty"`
+	ExpiresAt      time.Time  `json:"expiresAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+	ConfirmedAt    *time.Time `json:"confirmedAt,omitempty"`
+}
+
+// Holds gravados em um journal, como as vendas. Cada mudança de estado é gravada antes de ser aplicada.
+type Holds struct {
+	mu sync.Mutex
+
+	ttl           time.Duration
+	holds         map[string]*Hold
+	keys          map[string]string // Idempotency-Key -> id do hold
+	journal       *journal.Journal
+	snapshotEvery int
+}
+
+// NewHolds recupera os holds gravados. Deve ser chamado depois de NewSales: os holds HELD voltam a ocupar
+// o assento e um hold confirmado cuja venda não chegou a ser gravada tem a venda recriada.
+func NewHolds(ttl time.Duration, c StoreConfig) (*Holds, error) {
+	journal, err := journal.Open(c.Dir, "holds", c.Journal)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Holds{
+		ttl:           ttl,
+		holds:         make(map[string]*Hold),
+		keys:          make(map[string]string),
+		journal:       journal,
+		snapshotEvery: c.SnapshotEvery,
+	}
+	err = journal.Load(func(data []byte) error {
+		var hold Hold
+		if err := json.Unmarshal(data, &hold); err != nil {
+			return err
+		}
+		h.holds[hold.ID] = &hold
+		return nil
+	})
+	if err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("falha ao recuperar holds: %w", err)
+	}
+
+	// em ordem de criação, para que a chave fique com o hold mais recente
+	recovered := make([]*Hold, 0, len(h.holds))
+	for _, hold := range h.holds {
+		recovered = append(recovered, hold)
+	}
+	slices.SortFunc(recovered, func(a, b *Hold) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	for _, hold := range recovered {
+		if hold.IdempotencyKey != "" {
+			h.keys[hold.IdempotencyKey] = hold.ID
+		}
+		switch hold.Status {
+		case HoldStatusHeld:
+			inventory.Occupy(hold.Flight, hold.Day)
+		case HoldStatusConfirmed:
+			created, err := h.ensureSale(*hold)
+			if err != nil {
+				journal.Close()
+				return nil, err
+			}
+			if created {
+				log.Printf("[Hold] Venda %s do hold %s recriada na recuperação", hold.TransactionID, hold.ID)
+				inventory.Occupy(hold.Flight, hold.Day)
+			}
+		}
+	}
+
+	log.Printf("[Hold] %d hold(s) recuperado(s)", len(h.holds))
+	return h, nil
+}
+
+// Deve ser chamado com h.mu travado
+func (h *Holds) write(hold Hold) error {
+	if err := h.journal.Append(hold); err != nil {
+		return fmt.Errorf("falha ao gravar hold %s: %w", hold.ID, err)
+	}
+	h.holds[hold.ID] = &hold
+
+	if h.journal.Records() >= h.snapshotEvery {
+		h.snapshot()
+	}
+	return nil
+}
+
+// Deve ser chamado com h.mu travado
+func (h *Holds) snapshot() {
+	records := make([]any, 0, len(h.holds))
+	for _, hold := range h.holds {
+		records = append(records, hold)
+	}
+	if err := h.journal.Snapshot(records); err != nil {
+		log.Printf("[Hold] ERRO: falha ao gravar snapshot: %v", err)
+	}
+}
+
+// Deve ser chamado com h.mu travado. Grava a venda do hold confirmado, se ela ainda não existe
+// (queda ou falha de gravação entre a confirmação do hold e a gravação da venda).
+func (h *Holds) ensureSale(hold Hold) (created bool, err error) {
+	if _, ok := sales.Get(hold.TransactionID); ok {
+		return false, nil
+	}
+
+	sale := Sale{
+		TransactionID:  hold.TransactionID,
+		Flight:         hold.Flight,
+		Day:            hold.Day,
+		IdempotencyKey: hold.IdempotencyKey,
+		HoldID:         hold.ID,
+		Status:         SaleStatusSold,
+		SoldAt:         *hold.ConfirmedAt,
+	}
+	if err := sales.Add(sale); err != nil {
+		return false, err
+	}
+	if hold.IdempotencyKey != "" {
+		saleKeys.Complete(hold.IdempotencyKey, sale)
+	}
+	return true, nil
+}
+
+// Create reserva um assento do voo. Com Idempotency-Key, repetir a chamada devolve o hold já criado
+// (existing=true), a não ser que ele tenha sido liberado ou expirado: aí a compra desistiu do assento
+// e uma nova reserva é feita com a mesma chave. A chave de um hold de outro voo/dia retorna
+// ErrHoldKeyMismatch e a de um hold já confirmado retorna ErrHoldConfirmed (com o hold).
+// Com o voo lotado retorna ErrSoldOut.
+func (h *Holds) Create(flight, day, key string) (hold Hold, seats FlightSeats, existing bool, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	if id, ok := h.keys[key]; ok && key != "" {
+		previous := h.holds[id]
+		if previous.Flight != flight || previous.Day != day {
+			return Hold{}, FlightSeats{}, false, fmt.Errorf("%w (hold %s do voo %s no dia %s)", ErrHoldKeyMismatch, previous.ID, previous.Flight, previous.Day)
+		}
+		if previous.Status == HoldStatusHeld && !previous.ExpiresAt.After(now) {
+			if err := h.expire(previous, now); err != nil {
+				return Hold{}, FlightSeats{}, false, err
+			}
+			previous = h.holds[id]
+		}
+		switch previous.Status {
+		case HoldStatusHeld:
+			return *previous, inventory.Seats(flight, day), true, nil
+		case HoldStatusConfirmed:
+			return *previous, inventory.Seats(flight, day), true, ErrHoldConfirmed
+		}
+	}
+
+	// preço cotado antes de ocupar o assento, como o cliente viu no /flight
+	fare := fares.Quote(flight, day, inventory.Seats(flight, day), time.Now())
+	seats, err = inventory.Take(flight, day)
+	if err != nil {
+		return Hold{}, seats, false, err
+	}
+
+	hold = Hold{
+		ID:             uuid.New().String(),
+		Flight:         flight,
+		Day:            day,
+		Value:          fare.Value,
+		FareClass:      fare.Class,
+		Status:         HoldStatusHeld,
+		IdempotencyKey: key,
+		ExpiresAt:      now.Add(h.ttl),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := h.write(hold); err != nil {
+		inventory.Return(flight, day)
+		return Hold{}, seats, false, err
+	}
+	if key != "" {
+		h.keys[key] = hold.ID
+	}
+	return hold, seats, false, nil
+}
+
+func (h *Holds) Get(id string) (Hold, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hold, ok := h.holds[id]
+	if !ok {
+		return Hold{}, false
+	}
+	return *hold, true
+}
+
+// Confirm transforma o hold em venda. É idempotente: confirmar de novo devolve a mesma venda.
+// Um hold vencido é expirado aqui mesmo, sem esperar o sweeper, e não pode mais ser confirmado.
+// O hold confirmado é gravado antes da venda; se a venda não chegar a ser gravada, ela é criada
+// na próxima confirmação ou na recuperação.
+func (h *Holds) Confirm(id string) (Hold, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hold, ok := h.holds[id]
+	if !ok {
+		return Hold{}, fmt.Errorf("%w: %s", ErrHoldNotFound, id)
+	}
+
+	now := time.Now()
+	if hold.Status == HoldStatusHeld && !hold.ExpiresAt.After(now) {
+		if err := h.expire(hold, now); err != nil {
+			return *hold, err
+		}
+		hold = h.holds[id]
+	}
+	switch hold.Status {
+	case HoldStatusConfirmed:
+		_, err := h.ensureSale(*hold)
+		return *hold, err
+	case HoldStatusReleased, HoldStatusExpired:
+		return *hold, fmt.Errorf("%w: hold %s está %s", ErrHoldExpired, id, hold.Status)
+	}
+
+	confirmed := *hold
+	confirmed.Status = HoldStatusConfirmed
+	confirmed.TransactionID = uuid.New().String()
+	confirmed.ConfirmedAt = &now
+	confirmed.UpdatedAt = now
+	if err := h.write(confirmed); err != nil {
+		return *hold, err
+	}
+	_, err := h.ensureSale(confirmed)
+	return confirmed, err
+}
+
+// Release libera o hold, devolvendo o assento. Se o hold já foi confirmado, a venda é cancelada:
+// quem desfaz uma compra pode liberar o hold sem saber se a confirmação chegou.
+// Liberar um hold liberado ou expirado não tem efeito.
+func (h *Holds) Release(id string) (Hold, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hold, ok := h.holds[id]
+	if !ok {
+		return Hold{}, fmt.Errorf("%w: %s", ErrHoldNotFound, id)
+	}
+	if hold.Status != HoldStatusHeld && hold.Status != HoldStatusConfirmed {
+		return *hold, nil
+	}
+
+	// a venda é cancelada primeiro: se a liberação do hold não for gravada, liberar de novo
+	// encontra a venda já cancelada e não devolve o assento duas vezes
+	returnSeat := hold.Status == HoldStatusHeld
+	if hold.Status == HoldStatusConfirmed {
+		_, found, err := sales.Cancel(hold.TransactionID)
+		if err != nil {
+			return *hold, err
+		}
+		// sem a venda gravada, o assento ainda é do hold
+		returnSeat = !found
+	}
+
+	released := *hold
+	released.Status = HoldStatusReleased
+	released.UpdatedAt = time.Now()
+	if err := h.write(released); err != nil {
+		return *hold, err
+	}
+	if returnSeat {
+		inventory.Return(released.Flight, released.Day)
+	}
+	return released, nil
+}
+
+// Deve ser chamado com h.mu travado
+func (h *Holds) expire(hold *Hold, now time.Time) error {
+	expired := *hold
+	expired.Status = HoldStatusExpired
+	expired.UpdatedAt = now
+	if err := h.write(expired); err != nil {
+		return err
+	}
+	inventory.Return(expired.Flight, expired.Day)
+	log.Printf("[Hold] Hold %s do voo %s no dia %s expirou sem confirmação, assento devolvido", hold.ID, hold.Flight, hold.Day)
+	return nil
+}
+
+// Expire expira os holds vencidos ainda não confirmados
+func (h *Holds) Expire(now time.Time) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	expired := 0
+	for _, hold := range h.holds {
+		if hold.Status == HoldStatusHeld && !hold.ExpiresAt.After(now) {
+			if err := h.expire(hold, now); err != nil {
+				log.Printf("[Hold] ERRO: falha ao expirar hold %s: %v", hold.ID, err)
+				continue
+			}
+			expired++
+		}
+	}
+	return expired
+}
+
+func (h *Holds) sweepLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		if expired := h.Expire(time.Now()); expired > 0 {
+			log.Printf("[Hold] %d hold(s) expirado(s)", expired)
+		}
+	}
+}
+
+func (h *Holds) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.snapshot()
+	return h.journal.Close()
+}
+
+func newHoldsFromEnv(c StoreConfig) (*Holds, error) {
+	ttl, err := time.ParseDuration(getEnv("HOLD_TTL", "2m"))
+	if err != nil || ttl <= 0 {
+		log.Printf("Valor inválido para HOLD_TTL, usando 2m")
+		ttl = 2 * time.Minute
+	}
+	interval, err := time.ParseDuration(getEnv("HOLD_SWEEP_INTERVAL", "5s"))
+	if err != nil || interval <= 0 {
+		log.Printf("Valor inválido para HOLD_SWEEP_INTERVAL, usando 5s")
+		interval = 5 * time.Second
+	}
+
+	h, err := NewHolds(ttl, c)
+	if err != nil {
+		return nil, err
+	}
+	go h.sweepLoop(interval)
+	return h, nil
+}
+
+var holds *Holds
+
+func writeHold(w http.ResponseWriter, statusCode int, hold Hold) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(hold)
+}
+
+func writeHoldError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrHoldNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrHoldExpired):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}{Error: "HOLD_EXPIRED", Message: err.Error()})
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// POST /holds
+func createHoldHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel, ok := deadline.Context(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	var req FlightRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "JSON inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Flight == "" || req.Day == "" {
+		http.Error(w, "flight e day são obrigatórios", http.StatusBadRequest)
+		return
+	}
+
+	if deadline.Exceeded(ctx, w) {
+		return
+	}
+
+	hold, seats, existing, err := holds.Create(req.Flight, req.Day, r.Header.Get(idempotencyKeyHeader))
+	if errors.Is(err, ErrSoldOut) {
+		log.Printf("[Hold] Reserva recusada: %v", err)
+		writeSoldOut(w, seats, err)
+		return
+	}
+	if errors.Is(err, ErrHoldKeyMismatch) {
+		log.Printf("[Hold] Reserva recusada: %v", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, ErrHoldConfirmed) {
+		log.Printf("[Hold] Reserva recusada: Idempotency-Key do hold %s, já confirmado (venda %s)", hold.ID, hold.TransactionID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(struct {
+			Error         string `json:"error"`
+			Message       string `json:"message"`
+			HoldID        string `json:"holdID"`
+			TransactionID string `json:"transactionID"`
+		}{Error: "HOLD_CONFIRMED", Message: err.Error(), HoldID: hold.ID, TransactionID: hold.TransactionID})
+		return
+	}
+	if err != nil {
+		log.Printf("[Hold] ERRO: %v", err)
+		http.Error(w, "Erro ao reservar assento", http.StatusInternalServerError)
+		return
+	}
+	if existing {
+		log.Printf("[Hold] Hold com Idempotency-Key %s já criado: %s", hold.IdempotencyKey, hold.ID)
+		w.Header().Set("Idempotent-Replayed", "true")
+		writeHold(w, http.StatusOK, hold)
+		return
+	}
+
+	log.Printf("[Hold] Assento do voo %s no dia %s reservado até %s (hold %s, %.2f, %d de %d assentos ocupados)",
+		hold.Flight, hold.Day, hold.ExpiresAt.Format(time.RFC3339), hold.ID, hold.Value, seats.Sold, seats.Capacity)
+	writeHold(w, http.StatusCreated, hold)
+}
+
+// GET /holds/{id}
+func getHoldHandler(w http.ResponseWriter, r *http.Request) {
+	hold, ok := holds.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, ErrHoldNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	writeHold(w, http.StatusOK, hold)
+}
+
+// POST /holds/{id}/confirm
+func confirmHoldHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel, ok := deadline.Context(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	// a confirmação é a venda de fato e sofre a mesma falha de lentidão do /sell
+	if simulateSellDelay(ctx, w) {
+		return
+	}
+
+	hold, err := holds.Confirm(r.PathValue("id"))
+	if err != nil {
+		log.Printf("[Hold] Confirmação recusada: %v", err)
+		writeHoldError(w, err)
+		return
+	}
+	log.Printf("[Hold] Hold %s confirmado. Venda: %s", hold.ID, hold.TransactionID)
+	writeHold(w, http.StatusOK, hold)
+}
+
+// POST /holds/{id}/release
+func releaseHoldHandler(w http.ResponseWriter, r *http.Request) {
+	hold, err := holds.Release(r.PathValue("id"))
+	if err != nil {
+		writeHoldError(w, err)
+		return
+	}
+	log.Printf("[Hold] Hold %s liberado (%s)", hold.ID, hold.Status)
+	writeHold(w, http.StatusOK, hold)
+}
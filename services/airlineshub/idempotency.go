@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+type saleKeyEntry struct {
+	flight        string // voo e dia da venda: a chave não pode ser reaproveitada para outro voo
+	day           string
+	transactionID string // vazio enquanto a venda está em andamento
+	expiresAt     time.Time
+}
+
+type saleKeyResult int
+
+const (
+	saleKeyNew        saleKeyResult = iota // chave reservada para uma nova venda
+	saleKeyReplay                          // venda já concluída com a chave
+	saleKeyInProgress                      // outra requisição com a chave ainda está vendendo
+	saleKeyMismatch                        // chave já usada para outro voo/dia
+)
+
+// Vendas já processadas por Idempotency-Key, para que uma venda repetida pelo IMDTravel
+// (retry após timeout, por exemplo) devolva o mesmo transactionID em vez de vender de novo.
+type SaleKeys struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*saleKeyEntry
+}
+
+func NewSaleKeys(ttl time.Duration) *SaleKeys {
+	k := &SaleKeys{
+		ttl:     ttl,
+		entries: make(map[string]*saleKeyEntry),
+	}
+	go k.sweepLoop()
+	return k
+}
+
+// Begin reserva a chave para uma venda do voo flight no dia day. Com saleKeyReplay, retorna o
+// transactionID da venda já concluída com a chave.
+func (k *SaleKeys) Begin(key, flight, day string) (transactionID string, result saleKeyResult) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if entry, ok := k.entries[key]; ok && entry.expiresAt.After(time.Now()) {
+		switch {
+		case entry.flight != flight || entry.day != day:
+			return "", saleKeyMismatch
+		case entry.transactionID == "":
+			return "", saleKeyInProgress
+		default:
+			return entry.transactionID, saleKeyReplay
+		}
+	}
+
+	k.entries[key] = &saleKeyEntry{flight: flight, day: day, expiresAt: time.Now().Add(k.ttl)}
+	return "", saleKeyNew
+}
+
+// Lookup consulta a chave sem reservá-la
+func (k *SaleKeys) Lookup(key string) (transactionID string, inProgress bool, found bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	entry, ok := k.entries[key]
+	if !ok || !entry.expiresAt.After(time.Now()) {
+		return "", false, false
+	}
+	return entry.transactionID, entry.transactionID == "", true
+}
+
+func (k *SaleKeys) Complete(key string, sale Sale) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.entries[key] = &saleKeyEntry{flight: sale.Flight, day: sale.Day, transactionID: sale.TransactionID, expiresAt: time.Now().Add(k.ttl)}
+}
+
+// Restore marca a chave como concluída por uma venda recuperada do disco, mantendo a validade
+// contada a partir da venda. Chaves já expiradas são ignoradas.
+func (k *SaleKeys) Restore(key string, sale Sale) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	expiresAt := sale.SoldAt.Add(k.ttl)
+	if expiresAt.After(time.Now()) {
+		k.entries[key] = &saleKeyEntry{flight: sale.Flight, day: sale.Day, transactionID: sale.TransactionID, expiresAt: expiresAt}
+	}
+}
+
+// Abandon libera a chave de uma venda que não foi concluída
+func (k *SaleKeys) Abandon(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if entry, ok := k.entries[key]; ok && entry.transactionID == "" {
+		delete(k.entries, key)
+	}
+}
+
+func (k *SaleKeys) sweepLoop() {
+	for range time.Tick(time.Minute) {
+		k.mu.Lock()
+		now := time.Now()
+		for key, entry := range k.entries {
+			if !entry.expiresAt.After(now) {
+				delete(k.entries, key)
+			}
+		}
+		k.mu.Unlock()
+	}
+}
+
+func saleKeysTTL() time.Duration {
+	ttl, err := time.ParseDuration(getEnv("SELL_IDEMPOTENCY_TTL", "24h"))
+	if err != nil {
+		log.Printf("Valor inválido para SELL_IDEMPOTENCY_TTL, usando 24h: %v", err)
+		return 24 * time.Hour
+	}
+	return ttl
+}
+
+var saleKeys = NewSaleKeys(saleKeysTTL())
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrSoldOut = errors.New("voo esgotado")
+
+// Capacidade dos voos cujo código casa com Flight (padrão do path.Match, ex: "05A8*")
+type CapacityRule struct {
+	Flight   string
+	Capacity int
+}
+
+type FlightSeats struct {
+	Flight    string `json:"flight"`
+	Day       string `json:"day"`
+	Capacity  int    `json:"capacity"`
+	Sold      int    `json:"sold"`
+	Available int    `json:"available"`
+}
+
+// Assentos vendidos de cada voo/dia. Todo voo tem a capacidade padrão, a não ser que
+// alguma regra (a primeira que casar com o código do voo) defina outra.
+type SeatInventory struct {
+	mu sync.Mutex
+
+	capacity int
+	rules    []CapacityRule
+	sold     map[string]int // por voo|dia
+}
+
+func NewSeatInventory(capacity int, rules []CapacityRule) *SeatInventory {
+	return &SeatInventory{
+		capacity: capacity,
+		rules:    rules,
+		sold:     make(map[string]int),
+	}
+}
+
+func seatsKey(flight, day string) string {
+	return flight + "|" + day
+}
+
+func (i *SeatInventory) capacityOf(flight string) int {
+	for _, rule := range i.rules {
+		if matched, _ := path.Match(rule.Flight, flight); matched {
+			return rule.Capacity
+		}
+	}
+	return i.capacity
+}
+
+// Deve ser chamado com i.mu travado
+func (i *SeatInventory) seats(flight, day string) FlightSeats {
+	capacity := i.capacityOf(flight)
+	sold := i.sold[seatsKey(flight, day)]
+	return FlightSeats{
+		Flight:    flight,
+		Day:       day,
+		Capacity:  capacity,
+		Sold:      sold,
+		Available: max(capacity-sold, 0),
+	}
+}
+
+func (i *SeatInventory) Seats(flight, day string) FlightSeats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.seats(flight, day)
+}
+
+// Take ocupa um assento do voo no dia. Com o voo lotado retorna ErrSoldOut e não ocupa nada;
+// a verificação e a ocupação acontecem sob a mesma trava, então vendas simultâneas nunca passam da capacidade.
+func (i *SeatInventory) Take(flight, day string) (FlightSeats, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	seats := i.seats(flight, day)
+	if seats.Available == 0 {
+		return seats, fmt.Errorf("%w: %s no dia %s (%d assentos)", ErrSoldOut, flight, day, seats.Capacity)
+	}
+	i.sold[seatsKey(flight, day)]++
+	return i.seats(flight, day), nil
+}
+
+// Occupy ocupa um assento sem verificar a capacidade. Usado na recuperação das vendas e holds
+// gravados, que já ocupavam o assento antes do reinício (mesmo que a capacidade tenha mudado).
+func (i *SeatInventory) Occupy(flight, day string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.sold[seatsKey(flight, day)]++
+}
+
+// Return devolve um assento (venda cancelada)
+func (i *SeatInventory) Return(flight, day string) FlightSeats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	key := seatsKey(flight, day)
+	if i.sold[key] > 0 {
+		i.sold[key]--
+	}
+	return i.seats(flight, day)
+}
+
+// Lê FLIGHT_CAPACITIES no formato "05A8EF14=120,05B7*=2"
+func parseCapacityRules(value string) []CapacityRule {
+	var rules []CapacityRule
+	for item := range strings.SplitSeq(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		flight, capacity, ok := strings.Cut(item, "=")
+		flight = strings.TrimSpace(flight)
+		parsed, err := strconv.Atoi(strings.TrimSpace(capacity))
+		if _, matchErr := path.Match(flight, ""); !ok || flight == "" || err != nil || parsed < 0 || matchErr != nil {
+			log.Printf("Valor inválido em FLIGHT_CAPACITIES (%q), ignorando", item)
+			continue
+		}
+		rules = append(rules, CapacityRule{Flight: flight, Capacity: parsed})
+	}
+	return rules
+}
+
+func newSeatInventoryFromEnv() *SeatInventory {
+	capacity, err := strconv.Atoi(getEnv("FLIGHT_CAPACITY", "180"))
+	if err != nil || capacity < 0 {
+		log.Printf("Valor inválido para FLIGHT_CAPACITY, usando 180")
+		capacity = 180
+	}
+	rules := parseCapacityRules(getEnv("FLIGHT_CAPACITIES", ""))
+	log.Printf("Capacidade padrão dos voos: %d assentos (%d regra(s) por voo)", capacity, len(rules))
+	return NewSeatInventory(capacity, rules)
+}
+
+var inventory = newSeatInventoryFromEnv()
+
+// Resposta 409 do /sell com o voo lotado. O campo error distingue o voo esgotado do
+// 409 de uma venda com o mesmo Idempotency-Key ainda em andamento.
+type SoldOutResponse struct {
+	Error   string `json:"error"` // SOLD_OUT
+	Message string `json:"message"`
+	FlightSeats
+}
+
+func writeSoldOut(w http.ResponseWriter, seats FlightSeats, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(SoldOutResponse{Error: "SOLD_OUT", Message: err.Error(), FlightSeats: seats})
+}
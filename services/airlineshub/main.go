@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"net/http"
-	"strconv"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/fsousabt/shared/deadline"
 	"github.com/google/uuid"
 )
 
@@ -59,29 +63,72 @@ type FlightRequest struct {
 }
 
 type FlightResponse struct {
-	Flight string  `json:"flight"`
-	Day    string  `json:"day"`
-	Value  float64 `json:"value"`
+	Flight         string  `json:"flight"`
+	Day            string  `json:"day"`
+	Value          float64 `json:"value"`
+	FareClass      string  `json:"fareClass"`
+	SeatsAvailable int     `json:"seatsAvailable"`
 }
 
 type SellResponse struct {
 	TransactionID string `json:"transactionID"`
 }
 
+func getEnv(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
 func main() {
 	serviceName := "AirlinesHub"
 	log.Printf("Iniciando serviço %s...", serviceName)
+
+	store := storeConfigFromEnv()
+	var err error
+	sales, err = NewSales(store)
+	if err != nil {
+		log.Fatalf("Falha ao abrir registro de vendas: %v", err)
+	}
+	holds, err = newHoldsFromEnv(store)
+	if err != nil {
+		log.Fatalf("Falha ao abrir registro de holds: %v", err)
+	}
+	go waitForShutdown(holds, sales)
+
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("GET /healthcheck", healthCheckHandler)
 	mux.HandleFunc("GET /flight", flightHandler)
 	mux.HandleFunc("POST /sell", sellHandler)
+	mux.HandleFunc("GET /transactions", findTransactionHandler)
+	mux.HandleFunc("GET /transactions/{id}", getTransactionHandler)
+	mux.HandleFunc("POST /transactions/{id}/cancel", cancelTransactionHandler)
+	mux.HandleFunc("POST /holds", createHoldHandler)
+	mux.HandleFunc("GET /holds/{id}", getHoldHandler)
+	mux.HandleFunc("POST /holds/{id}/confirm", confirmHoldHandler)
+	mux.HandleFunc("POST /holds/{id}/release", releaseHoldHandler)
 
 	port := ":80"
 	log.Printf("Serviço %s rodando na porta %s", serviceName, port[1:])
 	log.Fatal(http.ListenAndServe(port, mux))
 }
 
+func waitForShutdown(closers ...io.Closer) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+
+	log.Printf("Sinal %v recebido, encerrando serviço AirlinesHub...", sig)
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			log.Printf("ERRO: falha ao fechar recurso: %v", err)
+		}
+	}
+	os.Exit(0)
+}
+
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -93,13 +140,6 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func generateRandomFlightValue() string {
-	min := 100.0
-	max := 250.0
-	value := min + rand.Float64()*(max-min)
-	return fmt.Sprintf("%.2f", value)
-}
-
 func flightHandler(w http.ResponseWriter, r *http.Request) {
 	fail := Fail{
 		Type:        "Omission",
@@ -112,6 +152,16 @@ func flightHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ctx, cancel, ok := deadline.Context(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	if deadline.Exceeded(ctx, w) {
+		return
+	}
+
 	query := r.URL.Query()
 	flightCode := query.Get("flight")
 	flightDay := query.Get("day")
@@ -133,23 +183,24 @@ func flightHandler(w http.ResponseWriter, r *http.Request) {
 		Day:    flightDay,
 	}
 
-	randValue, err := strconv.ParseFloat(generateRandomFlightValue(), 64)
-	if err != nil {
-		log.Fatalf("Erro ao converter string válida: %v", err)
-	}
+	seats := inventory.Seats(flight.Flight, flight.Day)
+	fare := fares.Quote(flight.Flight, flight.Day, seats, time.Now())
 
-	log.Printf("Preço da passagem do voo: %v\n", randValue)
+	log.Printf("Preço da passagem do voo: %.2f (base %.2f, classe %s x%.2f, %d dias antes x%.2f, variação do dia x%.3f)\n",
+		fare.Value, fare.Base, fare.Class, fare.ClassFactor, fare.DaysToFlight, fare.AdvanceFactor, fare.DailyFactor)
 
 	f := Flight{
 		Code:  flight.Flight,
 		Day:   flight.Day,
-		Value: randValue,
+		Value: fare.Value,
 	}
 
 	flightResponse := FlightResponse{
-		Flight: f.Code,
-		Day:    f.Day,
-		Value:  f.Value,
+		Flight:         f.Code,
+		Day:            f.Day,
+		Value:          f.Value,
+		FareClass:      fare.Class,
+		SeatsAvailable: seats.Available,
 	}
 
 	log.Println("Retornando requisição com sucesso!")
@@ -159,8 +210,9 @@ func flightHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(flightResponse)
 }
 
-func sellHandler(w http.ResponseWriter, r *http.Request) {
-
+// Simulação de falha - Time - Request 3 | Time | 0.1 | 5s, no /sell e na confirmação de hold.
+// Retorna true se o prazo da requisição acabou durante a lentidão (a resposta 504 já foi enviada).
+func simulateSellDelay(ctx context.Context, w http.ResponseWriter) bool {
 	fail := Fail{
 		Type:        "Time",
 		Probability: 0.1,
@@ -177,9 +229,24 @@ func sellHandler(w http.ResponseWriter, r *http.Request) {
 
 		for i := fail.Duration; i > 0; i-- {
 			log.Printf("[FAILURE] (Time) Aguarde... %d", i)
-			time.Sleep(1 * time.Second)
+			select {
+			case <-time.After(1 * time.Second):
+			case <-ctx.Done():
+			}
+			if deadline.Exceeded(ctx, w) {
+				return true
+			}
 		}
 	}
+	return false
+}
+
+func sellHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel, ok := deadline.Context(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
 
 	var req FlightRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -189,15 +256,94 @@ func sellHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Flight == "" || req.Day == "" {
+		http.Error(w, "flight e day são obrigatórios", http.StatusBadRequest)
+		return
+	}
+
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key != "" {
+		transactionID, result := saleKeys.Begin(key, req.Flight, req.Day)
+		switch result {
+		case saleKeyMismatch:
+			log.Printf("Idempotency-Key %s já usado em uma venda de outro voo/dia", key)
+			http.Error(w, "Idempotency-Key já usado em uma venda de outro voo ou dia", http.StatusUnprocessableEntity)
+			return
+		case saleKeyInProgress:
+			log.Printf("Venda com Idempotency-Key %s ainda em andamento", key)
+			http.Error(w, "Venda com este Idempotency-Key ainda em andamento", http.StatusConflict)
+			return
+		case saleKeyReplay:
+			// uma venda cancelada não é devolvida como se estivesse valendo
+			if sale, ok := sales.Get(transactionID); ok && sale.Status == SaleStatusCancelled {
+				log.Printf("Venda com Idempotency-Key %s já processada e cancelada. ID: %s", key, transactionID)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(struct {
+					Error         string `json:"error"`
+					Message       string `json:"message"`
+					TransactionID string `json:"transactionID"`
+				}{Error: "SALE_CANCELLED", Message: "a venda com este Idempotency-Key foi cancelada", TransactionID: transactionID})
+				return
+			}
+			log.Printf("Venda com Idempotency-Key %s já processada. ID: %s", key, transactionID)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(SellResponse{TransactionID: transactionID})
+			return
+		}
+	}
+
+	completed := false
+	defer func() {
+		if key != "" && !completed {
+			saleKeys.Abandon(key)
+		}
+	}()
+
+	if simulateSellDelay(ctx, w) {
+		return
+	}
+
+	if deadline.Exceeded(ctx, w) {
+		return
+	}
+
 	log.Printf("Iniciando processo de venda: %+v", req)
 
+	seats, err := inventory.Take(req.Flight, req.Day)
+	if err != nil {
+		log.Printf("Venda recusada: %v", err)
+		writeSoldOut(w, seats, err)
+		return
+	}
+
 	transactionID := uuid.New()
+	sale := Sale{
+		TransactionID:  transactionID.String(),
+		Flight:         req.Flight,
+		Day:            req.Day,
+		IdempotencyKey: key,
+		Status:         SaleStatusSold,
+		SoldAt:         time.Now(),
+	}
+	if err := sales.Add(sale); err != nil {
+		log.Printf("ERRO: %v", err)
+		inventory.Return(req.Flight, req.Day)
+		http.Error(w, "Erro ao registrar venda", http.StatusInternalServerError)
+		return
+	}
+	if key != "" {
+		saleKeys.Complete(key, sale)
+		completed = true
+	}
 
 	resp := SellResponse{
 		TransactionID: transactionID.String(),
 	}
 
-	log.Printf("Venda processada com sucesso. ID: %s", resp.TransactionID)
+	log.Printf("Venda processada com sucesso. ID: %s (%d de %d assentos vendidos)", resp.TransactionID, seats.Sold, seats.Capacity)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
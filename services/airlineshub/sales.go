@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fsousabt/shared/journal"
+)
+
+const (
+	SaleStatusSold      = "SOLD"
+	SaleStatusCancelled = "CANCELLED"
+)
+
+type Sale struct {
+	TransactionID  string     `json:"transactionID"`
+	Flight         string     `json:"flight"`
+	Day            string     `json:"day"`
+	IdempotencyKey string     `json:"idempotencyKey,omitempty"`
+	HoldID         string     `json:"holdID,omitempty"` // hold confirmado que originou a venda
+	Status         string     `json:"status"`
+	SoldAt         time.Time  `json:"soldAt"`
+	CancelledAt    *time.Time `json:"cancelledAt,omitempty"`
+}
+
+// Onde e como as vendas e os holds são gravados em disco
+type StoreConfig struct {
+	Dir           string
+	Journal       journal.Config
+	SnapshotEvery int
+}
+
+func storeConfigFromEnv() StoreConfig {
+	c := StoreConfig{
+		Dir: getEnv("DATA_DIR", "data"),
+		Journal: journal.Config{
+			Sync:         journal.SyncPolicy(getEnv("AIRLINESHUB_FSYNC", string(journal.SyncAlways))),
+			SyncInterval: time.Second,
+		},
+		SnapshotEvery: 1000,
+	}
+	switch c.Journal.Sync {
+	case journal.SyncAlways, journal.SyncInterval, journal.SyncNever:
+	default:
+		log.Printf("Valor inválido para AIRLINESHUB_FSYNC (%q), usando %s", c.Journal.Sync, journal.SyncAlways)
+		c.Journal.Sync = journal.SyncAlways
+	}
+	if interval, err := time.ParseDuration(getEnv("AIRLINESHUB_FSYNC_INTERVAL", "1s")); err == nil && interval > 0 {
+		c.Journal.SyncInterval = interval
+	} else {
+		log.Printf("Valor inválido para AIRLINESHUB_FSYNC_INTERVAL, usando 1s")
+	}
+	if every, err := strconv.Atoi(getEnv("AIRLINESHUB_SNAPSHOT_EVERY", "1000")); err == nil && every > 0 {
+		c.SnapshotEvery = every
+	} else {
+		log.Printf("Valor inválido para AIRLINESHUB_SNAPSHOT_EVERY, usando 1000")
+	}
+	return c
+}
+
+// Vendas gravadas em um journal. Cada mudança é gravada antes de ser aplicada em memória,
+// então uma venda respondida ao cliente sobrevive a um reinício.
+type Sales struct {
+	mu sync.Mutex
+
+	sales         map[string]*Sale
+	byKey         map[string]string // Idempotency-Key -> transactionID da venda mais recente feita com ele
+	journal       *journal.Journal
+	snapshotEvery int
+}
+
+func NewSales(c StoreConfig) (*Sales, error) {
+	journal, err := journal.Open(c.Dir, "sales", c.Journal)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sales{
+		sales:         make(map[string]*Sale),
+		byKey:         make(map[string]string),
+		journal:       journal,
+		snapshotEvery: c.SnapshotEvery,
+	}
+	err = journal.Load(func(data []byte) error {
+		var sale Sale
+		if err := json.Unmarshal(data, &sale); err != nil {
+			return err
+		}
+		s.index(&sale)
+		return nil
+	})
+	if err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("falha ao recuperar vendas: %w", err)
+	}
+
+	// os assentos vendidos e as chaves de idempotência são reconstruídos a partir das vendas
+	recovered := make([]Sale, 0, len(s.sales))
+	for _, sale := range s.sales {
+		recovered = append(recovered, *sale)
+	}
+	slices.SortFunc(recovered, func(a, b Sale) int {
+		return a.SoldAt.Compare(b.SoldAt)
+	})
+	for _, sale := range recovered {
+		if sale.Status == SaleStatusSold {
+			inventory.Occupy(sale.Flight, sale.Day)
+		}
+		if sale.IdempotencyKey != "" {
+			saleKeys.Restore(sale.IdempotencyKey, sale)
+		}
+	}
+
+	log.Printf("[Vendas] %d venda(s) recuperada(s)", len(s.sales))
+	return s, nil
+}
+
+// Deve ser chamado com s.mu travado
+func (s *Sales) write(sale Sale) error {
+	if err := s.journal.Append(sale); err != nil {
+		return fmt.Errorf("falha ao gravar venda %s: %w", sale.TransactionID, err)
+	}
+	s.index(&sale)
+
+	if s.journal.Records() >= s.snapshotEvery {
+		s.snapshot()
+	}
+	return nil
+}
+
+// Deve ser chamado com s.mu travado (ou durante a recuperação)
+func (s *Sales) index(sale *Sale) {
+	s.sales[sale.TransactionID] = sale
+	if sale.IdempotencyKey == "" {
+		return
+	}
+	// vendas recuperadas chegam fora de ordem: o índice fica com a mais recente
+	if current, ok := s.sales[s.byKey[sale.IdempotencyKey]]; !ok || !current.SoldAt.After(sale.SoldAt) {
+		s.byKey[sale.IdempotencyKey] = sale.TransactionID
+	}
+}
+
+// Deve ser chamado com s.mu travado
+func (s *Sales) snapshot() {
+	records := make([]any, 0, len(s.sales))
+	for _, sale := range s.sales {
+		records = append(records, sale)
+	}
+	if err := s.journal.Snapshot(records); err != nil {
+		log.Printf("[Vendas] ERRO: falha ao gravar snapshot: %v", err)
+	}
+}
+
+func (s *Sales) Add(sale Sale) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(sale)
+}
+
+func (s *Sales) Get(transactionID string) (Sale, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sale, ok := s.sales[transactionID]
+	if !ok {
+		return Sale{}, false
+	}
+	return *sale, true
+}
+
+// FindByKey retorna a venda feita com o Idempotency-Key, mesmo depois de a chave expirar para novas vendas
+func (s *Sales) FindByKey(key string) (Sale, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sale, ok := s.sales[s.byKey[key]]
+	if !ok {
+		return Sale{}, false
+	}
+	return *sale, true
+}
+
+// Cancel devolve o assento da venda ao estoque do voo. É idempotente: cancelar uma venda já
+// cancelada não tem efeito (e não devolve o assento de novo).
+func (s *Sales) Cancel(transactionID string) (Sale, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sale, ok := s.sales[transactionID]
+	if !ok {
+		return Sale{}, false, nil
+	}
+	if sale.Status == SaleStatusCancelled {
+		return *sale, true, nil
+	}
+
+	cancelled := *sale
+	now := time.Now()
+	cancelled.Status = SaleStatusCancelled
+	cancelled.CancelledAt = &now
+	if err := s.write(cancelled); err != nil {
+		return *sale, true, err
+	}
+	inventory.Return(cancelled.Flight, cancelled.Day)
+	return cancelled, true, nil
+}
+
+func (s *Sales) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshot()
+	return s.journal.Close()
+}
+
+var sales *Sales
+
+func writeSale(w http.ResponseWriter, sale Sale) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(sale)
+}
+
+// GET /transactions?idempotencyKey=...
+func findTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("idempotencyKey")
+	if key == "" {
+		http.Error(w, "Falta parametro de busca: idempotencyKey", http.StatusBadRequest)
+		return
+	}
+
+	// as chaves em memória só dizem se uma venda com a chave está em andamento; as vendas gravadas
+	// são procuradas pelo índice, que não expira nem depende das chaves reconstruídas no reinício
+	if _, inProgress, _ := saleKeys.Lookup(key); inProgress {
+		http.Error(w, "Venda com este Idempotency-Key ainda em andamento", http.StatusConflict)
+		return
+	}
+
+	sale, ok := sales.FindByKey(key)
+	if !ok {
+		http.Error(w, "Nenhuma venda com este Idempotency-Key", http.StatusNotFound)
+		return
+	}
+	writeSale(w, sale)
+}
+
+// GET /transactions/{id}
+func getTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	sale, ok := sales.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Venda não encontrada", http.StatusNotFound)
+		return
+	}
+	writeSale(w, sale)
+}
+
+// POST /transactions/{id}/cancel
+func cancelTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	transactionID := r.PathValue("id")
+
+	sale, ok, err := sales.Cancel(transactionID)
+	if !ok {
+		http.Error(w, "Venda não encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERRO: %v", err)
+		http.Error(w, "Erro ao cancelar venda", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Venda %s cancelada", transactionID)
+	writeSale(w, sale)
+}
@@ -1,3 +1,7 @@
 module exchange-service
 
 go 1.25.3
+
+require github.com/fsousabt/shared v0.0.0
+
+replace github.com/fsousabt/shared => ../shared
@@ -15,6 +15,8 @@ import (
 	"math/rand"
 	"net/http"
 	"time"
+
+	"github.com/fsousabt/shared/deadline"
 )
 
 type ExchangeToDolarResponse struct {
@@ -69,6 +71,16 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func conversionToDolar(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel, ok := deadline.Context(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	if deadline.Exceeded(ctx, w) {
+		return
+	}
+
 	rate, err := getDolarRatePrice()
 	if err != nil {
 		errMsg := struct {
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/fsousabt/shared/journal"
+)
+
+// Pontos acumulados na janela móvel necessários para cada categoria
+type TierConfig struct {
+	Window           time.Duration
+	EvaluateInterval time.Duration
+	Silver           int
+	Gold             int
+	Platinum         int
+}
+
+type ExpiryConfig struct {
+	After         time.Duration // validade dos pontos a partir do crédito
+	SweepInterval time.Duration
+}
+
+type Config struct {
+	DataDir       string
+	Journal       journal.Config
+	SnapshotEvery int
+	Tier          TierConfig
+	Expiry        ExpiryConfig
+}
+
+const (
+	DATA_DIR                = "DATA_DIR"
+	FIDELITY_FSYNC          = "FIDELITY_FSYNC"
+	FIDELITY_FSYNC_INTERVAL = "FIDELITY_FSYNC_INTERVAL"
+	FIDELITY_SNAPSHOT_EVERY = "FIDELITY_SNAPSHOT_EVERY"
+
+	TIER_WINDOW            = "TIER_WINDOW"
+	TIER_EVALUATE_INTERVAL = "TIER_EVALUATE_INTERVAL"
+	TIER_SILVER_POINTS     = "TIER_SILVER_POINTS"
+	TIER_GOLD_POINTS       = "TIER_GOLD_POINTS"
+	TIER_PLATINUM_POINTS   = "TIER_PLATINUM_POINTS"
+
+	POINTS_EXPIRY         = "POINTS_EXPIRY"
+	EXPIRY_SWEEP_INTERVAL = "EXPIRY_SWEEP_INTERVAL"
+)
+
+func getEnv(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Valor inválido para %s (%q), usando padrão %d", name, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Valor inválido para %s (%q), usando padrão %v", name, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvSyncPolicy(name string, fallback journal.SyncPolicy) journal.SyncPolicy {
+	policy := journal.SyncPolicy(os.Getenv(name))
+	switch policy {
+	case journal.SyncAlways, journal.SyncInterval, journal.SyncNever:
+		return policy
+	case "":
+		return fallback
+	}
+	log.Printf("Valor inválido para %s (%q), usando padrão %s", name, policy, fallback)
+	return fallback
+}
+
+func MakeConfig() Config {
+	return Config{
+		DataDir: getEnv(DATA_DIR, "data"),
+		Journal: journal.Config{
+			Sync:         getEnvSyncPolicy(FIDELITY_FSYNC, journal.SyncAlways),
+			SyncInterval: getEnvDuration(FIDELITY_FSYNC_INTERVAL, time.Second),
+		},
+		SnapshotEvery: getEnvInt(FIDELITY_SNAPSHOT_EVERY, 1000),
+		Tier: TierConfig{
+			Window:           getEnvDuration(TIER_WINDOW, 365*24*time.Hour),
+			EvaluateInterval: getEnvDuration(TIER_EVALUATE_INTERVAL, time.Minute),
+			Silver:           getEnvInt(TIER_SILVER_POINTS, 1000),
+			Gold:             getEnvInt(TIER_GOLD_POINTS, 5000),
+			Platinum:         getEnvInt(TIER_PLATINUM_POINTS, 15000),
+		},
+		Expiry: ExpiryConfig{
+			After:         getEnvDuration(POINTS_EXPIRY, 2*365*24*time.Hour),
+			SweepInterval: getEnvDuration(EXPIRY_SWEEP_INTERVAL, time.Minute),
+		},
+	}
+}
+
+var cfg = MakeConfig()
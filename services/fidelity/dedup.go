@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fsousabt/shared/journal"
+)
+
+// Resultado de um bônus já processado, devolvido para requisições repetidas com o mesmo transactionID
+type BonusOutcome struct {
+	TransactionID string    `json:"transactionID"`
+	User          string    `json:"user"`
+	Bonus         int       `json:"bonus"`
+	StatusCode    int       `json:"statusCode"`
+	Message       string    `json:"message"`
+	ProcessedAt   time.Time `json:"processedAt"`
+}
+
+// Bônus já processados, indexados pelo transactionID da venda (chave de deduplicação).
+// O IMDTravel entrega bônus pelo menos uma vez (retries, fila persistente); guardando as chaves
+// em disco, cada venda credita pontos no máximo uma vez.
+type ProcessedBonuses struct {
+	mu sync.Mutex
+
+	outcomes      map[string]BonusOutcome
+	journal       *journal.Journal
+	snapshotEvery int
+}
+
+// O crédito é gravado no ledger antes da chave de deduplicação. Se o serviço cair entre as duas
+// gravações, a chave é reconstruída a partir do lançamento do ledger ao abrir o registro.
+func NewProcessedBonuses(c Config, ledger *Ledger) (*ProcessedBonuses, error) {
+	journal, err := journal.Open(c.DataDir, "processed-bonuses", c.Journal)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ProcessedBonuses{
+		outcomes:      make(map[string]BonusOutcome),
+		journal:       journal,
+		snapshotEvery: max(c.SnapshotEvery, 1),
+	}
+
+	err = journal.Load(func(data []byte) error {
+		var outcome BonusOutcome
+		if err := json.Unmarshal(data, &outcome); err != nil {
+			return err
+		}
+		p.outcomes[outcome.TransactionID] = outcome
+		return nil
+	})
+	if err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("falha ao recuperar bônus processados: %w", err)
+	}
+
+	for _, entry := range ledger.Credits() {
+		if _, ok := p.outcomes[entry.TransactionID]; ok {
+			continue
+		}
+		log.Printf("[Dedup] Venda %s creditada no ledger sem registro de deduplicação, reconstruindo", entry.TransactionID)
+		outcome := newBonusOutcome(BonusRequest{User: entry.User, Bonus: entry.Points, TransactionID: entry.TransactionID}, entry.CreatedAt)
+		if err := p.journal.Append(outcome); err != nil {
+			journal.Close()
+			return nil, fmt.Errorf("falha ao reconstruir bônus processado %s: %w", entry.TransactionID, err)
+		}
+		p.outcomes[entry.TransactionID] = outcome
+	}
+
+	log.Printf("[Dedup] %d bônus processado(s) recuperado(s)", len(p.outcomes))
+	return p, nil
+}
+
+// Process credita o bônus uma única vez por transactionID. Se a chave já foi processada, retorna
+// o resultado original com duplicate=true sem chamar credit. A chave só é gravada se credit tiver sucesso.
+func (p *ProcessedBonuses) Process(req BonusRequest, credit func() error) (outcome BonusOutcome, duplicate bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if outcome, ok := p.outcomes[req.TransactionID]; ok {
+		return outcome, true, nil
+	}
+
+	if err := credit(); err != nil {
+		return BonusOutcome{}, false, err
+	}
+
+	outcome = newBonusOutcome(req, time.Now())
+	if err := p.journal.Append(outcome); err != nil {
+		return BonusOutcome{}, false, fmt.Errorf("falha ao gravar bônus processado %s: %w", req.TransactionID, err)
+	}
+	p.outcomes[req.TransactionID] = outcome
+
+	if p.journal.Records() >= p.snapshotEvery {
+		p.snapshot()
+	}
+	return outcome, false, nil
+}
+
+func newBonusOutcome(req BonusRequest, processedAt time.Time) BonusOutcome {
+	return BonusOutcome{
+		TransactionID: req.TransactionID,
+		User:          req.User,
+		Bonus:         req.Bonus,
+		StatusCode:    200,
+		Message:       "Bônus registrado com sucesso",
+		ProcessedAt:   processedAt,
+	}
+}
+
+// Deve ser chamado com p.mu travado
+func (p *ProcessedBonuses) snapshot() {
+	records := make([]any, 0, len(p.outcomes))
+	for _, outcome := range p.outcomes {
+		records = append(records, outcome)
+	}
+	if err := p.journal.Snapshot(records); err != nil {
+		log.Printf("[Dedup] ERRO: falha ao gravar snapshot: %v", err)
+	}
+}
+
+func (p *ProcessedBonuses) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.snapshot()
+	return p.journal.Close()
+}
+
+var processedBonuses *ProcessedBonuses
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fsousabt/shared/journal"
+)
+
+func openTestProcessedBonuses(t *testing.T, dir string, l *Ledger) *ProcessedBonuses {
+	t.Helper()
+	p, err := NewProcessedBonuses(Config{
+		DataDir:       dir,
+		Journal:       journal.Config{Sync: journal.SyncNever},
+		SnapshotEvery: 1000,
+	}, l)
+	if err != nil {
+		t.Fatalf("NewProcessedBonuses: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestProcessedBonusesAfterRestart(t *testing.T) {
+	req := BonusRequest{User: "ana", Bonus: 100, TransactionID: "venda-1"}
+
+	tests := []struct {
+		name string
+		// o que aconteceu com o bônus antes da queda
+		before        func(t *testing.T, l *Ledger, p *ProcessedBonuses)
+		wantDuplicate bool
+		wantBalance   int
+	}{
+		{
+			name: "crédito e chave gravados",
+			before: func(t *testing.T, l *Ledger, p *ProcessedBonuses) {
+				if _, _, err := p.Process(req, func() error {
+					_, err := l.Credit(req.User, req.Bonus, req.TransactionID)
+					return err
+				}); err != nil {
+					t.Fatalf("Process: %v", err)
+				}
+			},
+			wantDuplicate: true,
+			wantBalance:   100,
+		},
+		{
+			name: "queda entre o crédito e a chave",
+			before: func(t *testing.T, l *Ledger, p *ProcessedBonuses) {
+				if _, err := l.Credit(req.User, req.Bonus, req.TransactionID); err != nil {
+					t.Fatalf("Credit: %v", err)
+				}
+			},
+			wantDuplicate: true,
+			wantBalance:   100,
+		},
+		{
+			name: "crédito que falhou não grava a chave",
+			before: func(t *testing.T, l *Ledger, p *ProcessedBonuses) {
+				if _, _, err := p.Process(req, func() error { return errors.New("disco cheio") }); err == nil {
+					t.Fatalf("Process com falha no crédito não retornou erro")
+				}
+			},
+			wantBalance: 100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := openTestLedger(t, dir, time.Hour)
+			tt.before(t, l, openTestProcessedBonuses(t, dir, l))
+
+			// reabre sem fechar, como depois de uma queda
+			l = openTestLedger(t, dir, time.Hour)
+			p := openTestProcessedBonuses(t, dir, l)
+
+			credited := false
+			outcome, duplicate, err := p.Process(req, func() error {
+				credited = true
+				_, err := l.Credit(req.User, req.Bonus, req.TransactionID)
+				return err
+			})
+			if err != nil {
+				t.Fatalf("Process: %v", err)
+			}
+			if duplicate != tt.wantDuplicate || credited == tt.wantDuplicate {
+				t.Fatalf("Process após reinício: duplicate=%v, creditou de novo=%v; want duplicate=%v", duplicate, credited, tt.wantDuplicate)
+			}
+			if outcome.User != req.User || outcome.Bonus != req.Bonus || outcome.StatusCode != 200 {
+				t.Fatalf("resultado = %+v", outcome)
+			}
+			if balance, _, _ := l.Balance(req.User); balance != tt.wantBalance {
+				t.Fatalf("saldo = %d, want %d", balance, tt.wantBalance)
+			}
+		})
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fsousabt/shared/journal"
+)
+
+const (
+	EntryCredit   = "CREDIT"
+	EntryDebit    = "DEBIT"    // resgate de pontos confirmado
+	EntryRefund   = "REFUND"   // devolução de um resgate confirmado
+	EntryExpire   = "EXPIRE"   // expiração do saldo restante de um lote
+	EntryReversal = "REVERSAL" // estorno do bônus de uma venda cancelada
+)
+
+// Lançamento no extrato de pontos. Points é positivo para créditos e negativo para débitos.
+type LedgerEntry struct {
+	Seq           int64     `json:"seq"`
+	User          string    `json:"user"`
+	Type          string    `json:"type"`
+	Points        int       `json:"points"`
+	BalanceAfter  int       `json:"balanceAfter"`
+	TransactionID string    `json:"transactionID,omitempty"` // venda que originou o lançamento
+	ReservationID string    `json:"reservationID,omitempty"` // reserva de resgate, em débitos e devoluções
+	ExpiresAt     time.Time `json:"expiresAt,omitzero"`      // validade do lote criado por um crédito
+	LotID         int64     `json:"lotID,omitempty"`         // lote expirado ou estornado
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+const (
+	ReservationReserved = "RESERVED"
+	ReservationRedeemed = "REDEEMED"
+	ReservationReleased = "RELEASED"
+)
+
+// Reserva de pontos para resgate. Os pontos reservados deixam de estar disponíveis, mas só saem
+// do saldo quando a reserva é confirmada (lançamento DEBIT).
+type Reservation struct {
+	ID            string    `json:"id"`
+	User          string    `json:"user"`
+	Points        int       `json:"points"`
+	Status        string    `json:"status"`
+	TransactionID string    `json:"transactionID,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// Registro do journal do ledger: um lançamento, uma mudança de reserva ou um estorno, ou um lançamento
+// junto com a reserva ou o estorno que o originou, gravados numa única linha para não ficarem pela
+// metade após uma queda.
+type ledgerRecord struct {
+	Entry       *LedgerEntry `json:"entry,omitempty"`
+	Reservation *Reservation `json:"reservation,omitempty"`
+	Reversal    *Reversal    `json:"reversal,omitempty"`
+}
+
+var (
+	ErrInsufficientPoints  = errors.New("saldo de pontos insuficiente")
+	ErrReservationNotFound = errors.New("reserva não encontrada")
+	ErrReservationConflict = errors.New("reserva em estado incompatível")
+	ErrReversalConflict    = errors.New("estorno de venda de outro usuário")
+	ErrBonusReversed       = errors.New("bônus de venda estornada")
+)
+
+// Extrato de pontos (ledger) append-only gravado em um journal. Cada lançamento é gravado (com fsync)
+// antes de ser aplicado em memória, então o saldo recuperado após uma queda bate com o que foi respondido.
+type Ledger struct {
+	mu sync.Mutex
+
+	entries       map[string][]LedgerEntry // por usuário, em ordem de lançamento
+	balances      map[string]int
+	reserved      map[string]int // pontos em reservas RESERVED, por usuário
+	reservations  map[string]Reservation
+	lots          map[string][]*Lot // por usuário, em ordem de crédito
+	lotsByID      map[int64]*Lot
+	creditLots    map[string]*Lot             // lote criado pelo bônus de cada venda, por transactionID
+	consumed      map[string][]lotConsumption // lotes consumidos pelo débito de cada reserva
+	reversals     map[string]Reversal         // por transactionID
+	expiry        time.Duration
+	lastSeq       int64
+	journal       *journal.Journal
+	snapshotEvery int
+}
+
+func NewLedger(c Config) (*Ledger, error) {
+	journal, err := journal.Open(c.DataDir, "ledger", c.Journal)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Ledger{
+		entries:       make(map[string][]LedgerEntry),
+		balances:      make(map[string]int),
+		reserved:      make(map[string]int),
+		reservations:  make(map[string]Reservation),
+		lots:          make(map[string][]*Lot),
+		lotsByID:      make(map[int64]*Lot),
+		creditLots:    make(map[string]*Lot),
+		consumed:      make(map[string][]lotConsumption),
+		reversals:     make(map[string]Reversal),
+		expiry:        c.Expiry.After,
+		journal:       journal,
+		snapshotEvery: max(c.SnapshotEvery, 1),
+	}
+
+	err = journal.Load(func(data []byte) error {
+		var record ledgerRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		l.apply(record)
+		return nil
+	})
+	if err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("falha ao recuperar ledger: %w", err)
+	}
+
+	log.Printf("[Ledger] %d lançamento(s) recuperado(s) de %d usuário(s)", l.lastSeq, len(l.balances))
+	return l, nil
+}
+
+// Um lançamento com seq já aplicado (log reaplicado sobre um snapshot que já o contém) é ignorado,
+// para não duplicar o extrato e os lotes.
+func (l *Ledger) apply(record ledgerRecord) {
+	if entry := record.Entry; entry != nil && entry.Seq > l.lastSeq {
+		l.entries[entry.User] = append(l.entries[entry.User], *entry)
+		l.balances[entry.User] = entry.BalanceAfter
+		l.lastSeq = entry.Seq
+		l.applyLots(*entry)
+	}
+	if reservation := record.Reservation; reservation != nil {
+		if previous, ok := l.reservations[reservation.ID]; ok && previous.Status == ReservationReserved {
+			l.reserved[previous.User] -= previous.Points
+		}
+		if reservation.Status == ReservationReserved {
+			l.reserved[reservation.User] += reservation.Points
+		}
+		l.reservations[reservation.ID] = *reservation
+	}
+	if reversal := record.Reversal; reversal != nil {
+		l.reversals[reversal.TransactionID] = *reversal
+	}
+}
+
+// Deve ser chamado com l.mu travado
+func (l *Ledger) newEntry(user, entryType string, points int, transactionID string) *LedgerEntry {
+	return &LedgerEntry{
+		Seq:           l.lastSeq + 1,
+		User:          user,
+		Type:          entryType,
+		Points:        points,
+		BalanceAfter:  l.balances[user] + points,
+		TransactionID: transactionID,
+		CreatedAt:     time.Now(),
+	}
+}
+
+// Deve ser chamado com l.mu travado
+func (l *Ledger) write(record ledgerRecord) error {
+	if err := l.journal.Append(record); err != nil {
+		return fmt.Errorf("falha ao gravar no ledger: %w", err)
+	}
+	l.apply(record)
+
+	if l.journal.Records() >= l.snapshotEvery {
+		l.snapshot()
+	}
+	return nil
+}
+
+// Credit credita pontos ao usuário. O bônus de uma venda já estornada não é creditado (ErrBonusReversed).
+func (l *Ledger) Credit(user string, points int, transactionID string) (LedgerEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.reversals[transactionID]; ok && transactionID != "" {
+		return LedgerEntry{}, fmt.Errorf("%w: %s", ErrBonusReversed, transactionID)
+	}
+
+	entry := l.newEntry(user, EntryCredit, points, transactionID)
+	entry.ExpiresAt = entry.CreatedAt.Add(l.expiry)
+	if err := l.write(ledgerRecord{Entry: entry}); err != nil {
+		return LedgerEntry{}, err
+	}
+	return *entry, nil
+}
+
+// Reserve reserva pontos do saldo disponível. É idempotente pelo id da reserva: repetir a chamada
+// devolve a reserva existente (com existing=true), desde que o usuário e os pontos sejam os mesmos.
+func (l *Ledger) Reserve(id, user string, points int) (reservation Reservation, existing bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if reservation, ok := l.reservations[id]; ok {
+		if reservation.User != user || reservation.Points != points {
+			return reservation, true, fmt.Errorf("%w: reserva %s já existe para %s com %d pontos", ErrReservationConflict, id, reservation.User, reservation.Points)
+		}
+		return reservation, true, nil
+	}
+
+	if available := l.balances[user] - l.reserved[user]; available < points {
+		return Reservation{}, false, fmt.Errorf("%w: %d pontos disponíveis, %d solicitados", ErrInsufficientPoints, available, points)
+	}
+
+	now := time.Now()
+	reservation = Reservation{
+		ID:        id,
+		User:      user,
+		Points:    points,
+		Status:    ReservationReserved,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := l.write(ledgerRecord{Reservation: &reservation}); err != nil {
+		return Reservation{}, false, err
+	}
+	return reservation, false, nil
+}
+
+// Redeem confirma a reserva, debitando os pontos do saldo. Confirmar de novo não tem efeito.
+func (l *Ledger) Redeem(id, transactionID string) (Reservation, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	reservation, ok := l.reservations[id]
+	if !ok {
+		return Reservation{}, fmt.Errorf("%w: %s", ErrReservationNotFound, id)
+	}
+	switch reservation.Status {
+	case ReservationRedeemed:
+		return reservation, nil
+	case ReservationReleased:
+		return reservation, fmt.Errorf("%w: reserva %s já foi liberada", ErrReservationConflict, id)
+	}
+
+	entry := l.newEntry(reservation.User, EntryDebit, -reservation.Points, transactionID)
+	entry.ReservationID = id
+	reservation.Status = ReservationRedeemed
+	reservation.TransactionID = transactionID
+	reservation.UpdatedAt = entry.CreatedAt
+	if err := l.write(ledgerRecord{Entry: entry, Reservation: &reservation}); err != nil {
+		return Reservation{}, err
+	}
+	return reservation, nil
+}
+
+// Release libera a reserva. Se ela já foi confirmada, os pontos debitados são devolvidos (REFUND),
+// então a compensação de uma compra pode liberar a reserva sem saber se a confirmação chegou.
+// Liberar de novo não tem efeito.
+func (l *Ledger) Release(id string) (Reservation, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	reservation, ok := l.reservations[id]
+	if !ok {
+		return Reservation{}, fmt.Errorf("%w: %s", ErrReservationNotFound, id)
+	}
+
+	record := ledgerRecord{Reservation: &reservation}
+	switch reservation.Status {
+	case ReservationReleased:
+		return reservation, nil
+	case ReservationRedeemed:
+		record.Entry = l.newEntry(reservation.User, EntryRefund, reservation.Points, reservation.TransactionID)
+		record.Entry.ReservationID = id
+	}
+
+	reservation.Status = ReservationReleased
+	reservation.UpdatedAt = time.Now()
+	if err := l.write(record); err != nil {
+		return Reservation{}, err
+	}
+	return reservation, nil
+}
+
+func (l *Ledger) Reservation(id string) (Reservation, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	reservation, ok := l.reservations[id]
+	return reservation, ok
+}
+
+// Balance retorna o saldo do usuário e quanto dele está reservado para resgates em andamento
+func (l *Ledger) Balance(user string) (balance, reserved int, updatedAt time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := l.entries[user]
+	if len(entries) > 0 {
+		updatedAt = entries[len(entries)-1].CreatedAt
+	}
+	return l.balances[user], l.reserved[user], updatedAt
+}
+
+// History retorna uma página do extrato do usuário, do lançamento mais recente para o mais antigo
+func (l *Ledger) History(user string, limit, offset int) (page []LedgerEntry, total int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := l.entries[user]
+	total = len(entries)
+	page = []LedgerEntry{}
+	for i := total - 1 - offset; i >= 0 && len(page) < limit; i-- {
+		page = append(page, entries[i])
+	}
+	return page, total
+}
+
+// Accrued soma os pontos creditados ao usuário a partir de since
+// (resgates e devoluções não contam, e créditos de vendas estornadas são descontados)
+func (l *Ledger) Accrued(user string, since time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	accrued := 0
+	entries := l.entries[user]
+	for i := len(entries) - 1; i >= 0 && entries[i].CreatedAt.After(since); i-- {
+		if _, reversed := l.reversals[entries[i].TransactionID]; reversed {
+			continue
+		}
+		if entries[i].Type == EntryCredit {
+			accrued += entries[i].Points
+		}
+	}
+	return accrued
+}
+
+func (l *Ledger) Users() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	users := make([]string, 0, len(l.entries))
+	for user := range l.entries {
+		users = append(users, user)
+	}
+	return users
+}
+
+// Credits retorna os créditos ligados a uma venda (usado para reconstruir as chaves de deduplicação)
+func (l *Ledger) Credits() []LedgerEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var credits []LedgerEntry
+	for _, entries := range l.entries {
+		for _, entry := range entries {
+			if entry.Type == EntryCredit && entry.TransactionID != "" {
+				credits = append(credits, entry)
+			}
+		}
+	}
+	return credits
+}
+
+// Deve ser chamado com l.mu travado. Os lançamentos nunca são removidos: o snapshot guarda todos
+// eles em ordem de seq (e as reservas e estornos) e só evita reprocessar o log inteiro na recuperação.
+func (l *Ledger) snapshot() {
+	entries := make([]LedgerEntry, 0, l.lastSeq)
+	for _, userEntries := range l.entries {
+		entries = append(entries, userEntries...)
+	}
+	slices.SortFunc(entries, func(a, b LedgerEntry) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+
+	records := make([]any, 0, len(entries)+len(l.reservations)+len(l.reversals))
+	for _, entry := range entries {
+		records = append(records, ledgerRecord{Entry: &entry})
+	}
+	for _, reservation := range l.reservations {
+		records = append(records, ledgerRecord{Reservation: &reservation})
+	}
+	for _, reversal := range l.reversals {
+		records = append(records, ledgerRecord{Reversal: &reversal})
+	}
+	if err := l.journal.Snapshot(records); err != nil {
+		log.Printf("[Ledger] ERRO: falha ao gravar snapshot: %v", err)
+	}
+}
+
+func (l *Ledger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.snapshot()
+	return l.journal.Close()
+}
+
+var ledger *Ledger
+
+type BalanceResponse struct {
+	User      string     `json:"user"`
+	Balance   int        `json:"balance"`
+	Reserved  int        `json:"reserved"`
+	Available int        `json:"available"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+type HistoryResponse struct {
+	Entries []LedgerEntry `json:"entries"`
+	Total   int           `json:"total"`
+	Limit   int           `json:"limit"`
+	Offset  int           `json:"offset"`
+}
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+func writeJSON(w http.ResponseWriter, statusCode int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(payload)
+}
+
+// GET /users/{user}/balance
+func balanceHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	balance, reserved, updatedAt := ledger.Balance(user)
+
+	response := BalanceResponse{User: user, Balance: balance, Reserved: reserved, Available: balance - reserved}
+	if !updatedAt.IsZero() {
+		response.UpdatedAt = &updatedAt
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// GET /users/{user}/history?limit=20&offset=0
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, err := parseQueryInt(query.Get("limit"), defaultPageLimit)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		http.Error(w, fmt.Sprintf("limit deve ser um número entre 1 e %d", maxPageLimit), http.StatusBadRequest)
+		return
+	}
+	offset, err := parseQueryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		http.Error(w, "offset deve ser um número maior ou igual a 0", http.StatusBadRequest)
+		return
+	}
+
+	entries, total := ledger.History(r.PathValue("user"), limit, offset)
+	writeJSON(w, http.StatusOK, HistoryResponse{
+		Entries: entries,
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+	})
+}
+
+func parseQueryInt(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// Página de items começando em offset, com até limit itens. Um offset além do fim resulta em página vazia.
+func paginate[T any](items []T, limit, offset int) []T {
+	offset = min(offset, len(items))
+	return items[offset : offset+min(limit, len(items)-offset)]
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Conteúdo de um arquivo do journal do ledger com os lançamentos dados, precedido do cabeçalho se header não for vazio
+func ledgerFile(t *testing.T, header string, entries ...LedgerEntry) string {
+	t.Helper()
+	var lines []string
+	if header != "" {
+		lines = append(lines, header)
+	}
+	for _, entry := range entries {
+		data, err := json.Marshal(ledgerRecord{Entry: &entry})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(data))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestLedgerRecoverySkipsReplayedEntries(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	credit := func(seq int64, user string, points, balance int) LedgerEntry {
+		return LedgerEntry{Seq: seq, User: user, Type: EntryCredit, Points: points, BalanceAfter: balance,
+			TransactionID: fmt.Sprintf("venda-%d", seq), ExpiresAt: expiresAt}
+	}
+	e1, e2, e3 := credit(1, "ana", 100, 100), credit(2, "ana", 50, 150), credit(3, "bruno", 30, 30)
+
+	tests := []struct {
+		name     string
+		snapshot string
+		log      string
+	}{
+		{
+			name: "só log",
+			log:  ledgerFile(t, "", e1, e2, e3),
+		},
+		{
+			name:     "log sem geração reaplicado sobre o snapshot",
+			snapshot: ledgerFile(t, "", e1, e2),
+			log:      ledgerFile(t, "", e2, e3),
+		},
+		{
+			name:     "log da mesma geração com lançamento já no snapshot",
+			snapshot: ledgerFile(t, `{"journalGeneration":1}`, e1, e2),
+			log:      ledgerFile(t, `{"journalGeneration":1}`, e2, e3),
+		},
+		{
+			name:     "log de geração anterior já contido no snapshot",
+			snapshot: ledgerFile(t, `{"journalGeneration":2}`, e1, e2, e3),
+			log:      ledgerFile(t, `{"journalGeneration":1}`, e2, e3),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.snapshot != "" {
+				if err := os.WriteFile(filepath.Join(dir, "ledger.snapshot"), []byte(tt.snapshot), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(dir, "ledger.log"), []byte(tt.log), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			l := openTestLedger(t, dir, time.Hour)
+			for user, want := range map[string]struct{ balance, entries int }{"ana": {150, 2}, "bruno": {30, 1}} {
+				balance, _, _ := l.Balance(user)
+				_, total := l.History(user, 10, 0)
+				if balance != want.balance || total != want.entries || len(l.lots[user]) != want.entries {
+					t.Fatalf("%s: saldo %d, %d lançamento(s), %d lote(s); want saldo %d e %d lançamento(s) e lote(s)",
+						user, balance, total, len(l.lots[user]), want.balance, want.entries)
+				}
+			}
+			if len(l.Credits()) != 3 {
+				t.Fatalf("Credits = %d, want 3", len(l.Credits()))
+			}
+
+			entry, err := l.Credit("ana", 10, "venda-4")
+			if err != nil {
+				t.Fatalf("Credit: %v", err)
+			}
+			if entry.Seq != 4 || entry.BalanceAfter != 160 {
+				t.Fatalf("novo lançamento = seq %d saldo %d, want seq 4 saldo 160", entry.Seq, entry.BalanceAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"cmp"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Lote de pontos criado por um crédito. Débitos consomem os lotes que vencem primeiro e,
+// depois do vencimento, o que sobrou do lote é expirado pelo sweeper (lançamento EXPIRE).
+// Os lotes não são gravados à parte: são reconstruídos a partir dos lançamentos do ledger.
+// Se o saldo do usuário está preso em reservas de resgate, a expiração do lote vencido é adiada:
+// o sweeper registra o adiamento no log e expira o lote quando a reserva é confirmada ou liberada.
+type Lot struct {
+	ID            int64     `json:"lotID"` // seq do crédito que criou o lote
+	User          string    `json:"-"`
+	TransactionID string    `json:"transactionID,omitempty"`
+	Points        int       `json:"points"`
+	Remaining     int       `json:"remaining"`
+	Expired       int       `json:"-"` // pontos do lote que expiraram
+	AccruedAt     time.Time `json:"accruedAt"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+}
+
+type lotConsumption struct {
+	lot    *Lot
+	points int
+}
+
+// Deve ser chamado com l.mu travado, na ordem dos lançamentos
+func (l *Ledger) applyLots(entry LedgerEntry) {
+	switch entry.Type {
+	case EntryCredit:
+		expiresAt := entry.ExpiresAt
+		if expiresAt.IsZero() {
+			// crédito gravado antes da validade existir
+			expiresAt = entry.CreatedAt.Add(l.expiry)
+		}
+		lot := &Lot{
+			ID:            entry.Seq,
+			User:          entry.User,
+			TransactionID: entry.TransactionID,
+			Points:        entry.Points,
+			Remaining:     entry.Points,
+			AccruedAt:     entry.CreatedAt,
+			ExpiresAt:     expiresAt,
+		}
+		l.lots[entry.User] = append(l.lots[entry.User], lot)
+		l.lotsByID[lot.ID] = lot
+		if entry.TransactionID != "" {
+			l.creditLots[entry.TransactionID] = lot
+		}
+
+	case EntryDebit:
+		l.consumed[entry.ReservationID] = l.consumeLots(entry.User, -entry.Points)
+
+	case EntryRefund:
+		// os pontos voltam para os lotes de onde saíram; se algum já venceu, o próximo sweep os expira
+		for _, consumption := range l.consumed[entry.ReservationID] {
+			consumption.lot.Remaining += consumption.points
+		}
+		delete(l.consumed, entry.ReservationID)
+
+	case EntryExpire:
+		if lot, ok := l.lotsByID[entry.LotID]; ok {
+			expired := min(lot.Remaining, -entry.Points)
+			lot.Remaining -= expired
+			lot.Expired += expired
+		}
+
+	case EntryReversal:
+		// o estorno sai primeiro do lote criado pelo bônus estornado; se parte dele já foi
+		// resgatada, o restante sai dos lotes que vencem primeiro
+		points := -entry.Points
+		if lot, ok := l.lotsByID[entry.LotID]; ok {
+			take := min(lot.Remaining, points)
+			lot.Remaining -= take
+			points -= take
+		}
+		l.consumeLots(entry.User, points)
+	}
+}
+
+// Deve ser chamado com l.mu travado. Consome points dos lotes que vencem primeiro.
+func (l *Ledger) consumeLots(user string, points int) []lotConsumption {
+	lots := slices.Clone(l.lots[user])
+	slices.SortStableFunc(lots, func(a, b *Lot) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+
+	var consumed []lotConsumption
+	for _, lot := range lots {
+		if points == 0 {
+			break
+		}
+		take := min(lot.Remaining, points)
+		if take == 0 {
+			continue
+		}
+		lot.Remaining -= take
+		points -= take
+		consumed = append(consumed, lotConsumption{lot: lot, points: take})
+	}
+	return consumed
+}
+
+// Lotes do usuário com saldo que vencem até before, do mais próximo do vencimento para o mais distante
+func (l *Ledger) Expirations(user string, before time.Time) []Lot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lots := []Lot{}
+	for _, lot := range l.lots[user] {
+		if lot.Remaining > 0 && !lot.ExpiresAt.After(before) {
+			lots = append(lots, *lot)
+		}
+	}
+	slices.SortStableFunc(lots, func(a, b Lot) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+	return lots
+}
+
+// Lotes vencidos em now que ainda têm saldo, em ordem de crédito
+func (l *Ledger) DueLots(now time.Time) []int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var due []int64
+	for _, lot := range l.lotsByID {
+		if lot.Remaining > 0 && !lot.ExpiresAt.After(now) {
+			due = append(due, lot.ID)
+		}
+	}
+	slices.SortFunc(due, cmp.Compare)
+	return due
+}
+
+// expireLot lança a expiração do que sobrou do lote. Cada lote é expirado em um lançamento próprio,
+// gravado antes de ser aplicado: se o serviço cair no meio de um sweep, os lotes já expirados ficam
+// com saldo zero e a próxima execução só trata os que faltaram.
+// Pontos presos em reservas não expiram até a reserva ser confirmada ou liberada: held é a parte do lote
+// vencido que ficou para depois por isso.
+func (l *Ledger) expireLot(id int64, now time.Time) (entry LedgerEntry, expired bool, held int, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lot, ok := l.lotsByID[id]
+	if !ok || lot.Remaining == 0 || lot.ExpiresAt.After(now) {
+		return LedgerEntry{}, false, 0, nil
+	}
+	points := max(min(lot.Remaining, l.balances[lot.User]-l.reserved[lot.User]), 0)
+	held = lot.Remaining - points
+	if points == 0 {
+		return LedgerEntry{}, false, held, nil
+	}
+
+	expire := l.newEntry(lot.User, EntryExpire, -points, lot.TransactionID)
+	expire.LotID = lot.ID
+	if err := l.write(ledgerRecord{Entry: expire}); err != nil {
+		return LedgerEntry{}, false, 0, err
+	}
+	return *expire, true, held, nil
+}
+
+// Expira periodicamente os lotes vencidos
+type ExpirySweeper struct {
+	ledger   *Ledger
+	interval time.Duration
+	deferred map[int64]int // pontos de cada lote vencido com a expiração adiada por reservas, no último sweep
+	done     chan struct{}
+}
+
+func NewExpirySweeper(c ExpiryConfig, ledger *Ledger) *ExpirySweeper {
+	return &ExpirySweeper{
+		ledger:   ledger,
+		interval: max(c.SweepInterval, time.Second),
+		deferred: make(map[int64]int),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start faz um sweep imediato (lotes podem ter vencido com o serviço fora do ar) e depois um a cada intervalo
+func (s *ExpirySweeper) Start() {
+	s.Sweep()
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.Sweep()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+func (s *ExpirySweeper) Sweep() {
+	now := time.Now()
+
+	expired := 0
+	deferred := make(map[int64]int)
+	for _, id := range s.ledger.DueLots(now) {
+		entry, ok, held, err := s.ledger.expireLot(id, now)
+		if err != nil {
+			log.Printf("[Expiração] ERRO: falha ao expirar lote %d: %v", id, err)
+			return
+		}
+		if ok {
+			expired++
+			log.Printf("[Expiração] %d pontos do lote %d do usuário %s expiraram (saldo %d)", -entry.Points, id, entry.User, entry.BalanceAfter)
+		}
+		if held > 0 {
+			deferred[id] = held
+			// o adiamento é registrado uma vez, não a cada sweep, enquanto os pontos presos não mudam
+			if s.deferred[id] != held {
+				log.Printf("[Expiração] Expiração de %d pontos do lote %d adiada: saldo preso em reservas de resgate", held, id)
+			}
+		}
+	}
+	s.deferred = deferred
+	if expired > 0 {
+		log.Printf("[Expiração] %d lote(s) expirado(s)", expired)
+	}
+}
+
+func (s *ExpirySweeper) Close() error {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	return nil
+}
+
+var expirySweeper *ExpirySweeper
+
+type ExpirationsResponse struct {
+	User        string `json:"user"`
+	Points      int    `json:"points"` // total que vence no período
+	Expirations []Lot  `json:"expirations"`
+}
+
+// GET /users/{user}/expirations?days=90
+func expirationsHandler(w http.ResponseWriter, r *http.Request) {
+	days, err := parseQueryInt(r.URL.Query().Get("days"), 90)
+	if err != nil || days < 0 {
+		http.Error(w, "days deve ser um número maior ou igual a 0", http.StatusBadRequest)
+		return
+	}
+
+	user := r.PathValue("user")
+	lots := ledger.Expirations(user, time.Now().AddDate(0, 0, days))
+
+	response := ExpirationsResponse{User: user, Expirations: lots}
+	for _, lot := range lots {
+		response.Points += lot.Remaining
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsousabt/shared/journal"
+)
+
+// Ledger em um diretório temporário; com expiry negativo os lotes já nascem vencidos
+func openTestLedger(t *testing.T, dir string, expiry time.Duration) *Ledger {
+	t.Helper()
+	l, err := NewLedger(Config{
+		DataDir:       dir,
+		Journal:       journal.Config{Sync: journal.SyncNever},
+		SnapshotEvery: 1000,
+		Expiry:        ExpiryConfig{After: expiry},
+	})
+	if err != nil {
+		t.Fatalf("NewLedger: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestExpireLotDefersPointsHeldByReservations(t *testing.T) {
+	tests := []struct {
+		name        string
+		reserved    int
+		wantExpired int
+		wantHeld    int
+	}{
+		{"sem reserva", 0, 100, 0},
+		{"reserva parcial", 30, 70, 30},
+		{"saldo todo reservado", 100, 0, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := openTestLedger(t, t.TempDir(), -time.Hour)
+			credit, err := l.Credit("ana", 100, "venda-1")
+			if err != nil {
+				t.Fatalf("Credit: %v", err)
+			}
+			if tt.reserved > 0 {
+				if _, _, err := l.Reserve("reserva-1", "ana", tt.reserved); err != nil {
+					t.Fatalf("Reserve: %v", err)
+				}
+			}
+
+			entry, expired, held, err := l.expireLot(credit.Seq, time.Now())
+			if err != nil {
+				t.Fatalf("expireLot: %v", err)
+			}
+			if expired != (tt.wantExpired > 0) || -entry.Points != tt.wantExpired || held != tt.wantHeld {
+				t.Fatalf("expireLot = %d pontos expirados (ok=%v), %d adiados; want %d e %d",
+					-entry.Points, expired, held, tt.wantExpired, tt.wantHeld)
+			}
+
+			// o lote continua vencido enquanto houver pontos adiados
+			due := l.DueLots(time.Now())
+			if (len(due) == 1) != (tt.wantHeld > 0) {
+				t.Fatalf("DueLots = %v com %d pontos adiados", due, tt.wantHeld)
+			}
+		})
+	}
+}
+
+func TestExpireLotAfterReservationIsReleased(t *testing.T) {
+	l := openTestLedger(t, t.TempDir(), -time.Hour)
+	credit, err := l.Credit("ana", 100, "venda-1")
+	if err != nil {
+		t.Fatalf("Credit: %v", err)
+	}
+	if _, _, err := l.Reserve("reserva-1", "ana", 100); err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+
+	if _, expired, held, _ := l.expireLot(credit.Seq, time.Now()); expired || held != 100 {
+		t.Fatalf("expireLot com saldo reservado: expired=%v held=%d", expired, held)
+	}
+
+	if _, err := l.Release("reserva-1"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	entry, expired, held, err := l.expireLot(credit.Seq, time.Now())
+	if err != nil || !expired || entry.Points != -100 || held != 0 {
+		t.Fatalf("expireLot após liberar a reserva = %+v, %v, %d, %v", entry, expired, held, err)
+	}
+	if balance, _, _ := l.Balance("ana"); balance != 0 {
+		t.Fatalf("saldo = %d, want 0", balance)
+	}
+}
@@ -2,17 +2,31 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fsousabt/shared/deadline"
 )
 
 type BonusRequest struct {
-	User  string `json:"user"`
-	Bonus int    `json:"bonus"`
+	User          string `json:"user"`
+	Bonus         int    `json:"bonus"`
+	TransactionID string `json:"transactionID,omitempty"` // chave de deduplicação
+}
+
+type BonusResponse struct {
+	Message string `json:"message"`
 }
 
+const idempotentReplayedHeader = "Idempotent-Replayed"
+
 type Fail struct {
 	Type        string
 	Probability float64
@@ -21,16 +35,62 @@ type Fail struct {
 func main() {
 	serviceName := "Fidelity"
 	log.Printf("Iniciando serviço %s...", serviceName)
+
+	var err error
+	ledger, err = NewLedger(cfg)
+	if err != nil {
+		log.Fatalf("Falha ao abrir ledger de pontos: %v", err)
+	}
+	processedBonuses, err = NewProcessedBonuses(cfg, ledger)
+	if err != nil {
+		log.Fatalf("Falha ao abrir registro de bônus processados: %v", err)
+	}
+	tiers, err = NewTierTracker(cfg, ledger)
+	if err != nil {
+		log.Fatalf("Falha ao abrir registro de categorias: %v", err)
+	}
+	expirySweeper = NewExpirySweeper(cfg.Expiry, ledger)
+	go waitForShutdown(expirySweeper, processedBonuses, tiers, ledger)
+
+	tiers.Start()
+	expirySweeper.Start()
+
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("GET /healthcheck", healthCheckHandler)
 	mux.HandleFunc("POST /bonus", bonusHandler)
+	mux.HandleFunc("GET /users/{user}/balance", balanceHandler)
+	mux.HandleFunc("GET /users/{user}/history", historyHandler)
+	mux.HandleFunc("GET /users/{user}/tier", tierHandler)
+	mux.HandleFunc("GET /users/{user}/expirations", expirationsHandler)
+	mux.HandleFunc("POST /redemptions", reserveHandler)
+	mux.HandleFunc("GET /redemptions/{id}", getReservationHandler)
+	mux.HandleFunc("POST /redemptions/{id}/confirm", redeemHandler)
+	mux.HandleFunc("POST /redemptions/{id}/release", releaseHandler)
+	mux.HandleFunc("POST /reversals", reverseHandler)
+	mux.HandleFunc("GET /reversals", listReversalsHandler)
+	mux.HandleFunc("GET /reversals/{transactionID}", getReversalHandler)
 
 	port := ":80"
 	log.Printf("Serviço %s rodando na porta %s", serviceName, port[1:])
 	log.Fatal(http.ListenAndServe(port, mux))
 }
 
+// Fecha os recursos persistentes (fazendo o fsync pendente) quando o container é parado
+func waitForShutdown(closers ...io.Closer) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+
+	log.Printf("Sinal %v recebido, encerrando serviço Fidelity...", sig)
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			log.Printf("ERRO: falha ao fechar recurso: %v", err)
+		}
+	}
+	os.Exit(0)
+}
+
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -43,6 +103,12 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func bonusHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel, ok := deadline.Context(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
 	if r.Method != http.MethodPost {
 		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
 		return
@@ -64,14 +130,62 @@ func bonusHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Erro ao decodificar JSON", http.StatusBadRequest)
 		return
 	}
+	if req.User == "" || req.Bonus < 0 {
+		http.Error(w, "user é obrigatório e bonus não pode ser negativo", http.StatusBadRequest)
+		return
+	}
 
-	log.Printf("Recebido bônus para usuário %s: %d pontos", req.User, req.Bonus)
+	if deadline.Exceeded(ctx, w) {
+		return
+	}
 
-	w.WriteHeader(http.StatusOK)
-	response := struct {
-		Message string `json:"message"`
-	}{
-		Message: "Bônus registrado com sucesso",
+	if req.TransactionID == "" {
+		log.Printf("AVISO: bônus para usuário %s sem transactionID, processando sem deduplicação", req.User)
+		entry, err := ledger.Credit(req.User, req.Bonus, "")
+		if err != nil {
+			log.Printf("ERRO: %v", err)
+			http.Error(w, "Erro ao registrar bônus", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Creditados %d pontos para usuário %s (saldo %d)", req.Bonus, req.User, entry.BalanceAfter)
+		tiers.Evaluate(req.User)
+		writeBonusResponse(w, http.StatusOK, "Bônus registrado com sucesso")
+		return
 	}
-	json.NewEncoder(w).Encode(response)
+
+	outcome, duplicate, err := processedBonuses.Process(req, func() error {
+		entry, err := ledger.Credit(req.User, req.Bonus, req.TransactionID)
+		if err != nil {
+			return err
+		}
+		log.Printf("Creditados %d pontos para usuário %s (venda %s, saldo %d)", req.Bonus, req.User, req.TransactionID, entry.BalanceAfter)
+		tiers.Evaluate(req.User)
+		return nil
+	})
+	if errors.Is(err, ErrBonusReversed) {
+		// aceito (para não ser reenviado) mas não creditado
+		log.Printf("[Estorno] Venda %s já estornada, bônus de %d para usuário %s ignorado", req.TransactionID, req.Bonus, req.User)
+		writeBonusResponse(w, http.StatusOK, "Venda estornada, bônus ignorado")
+		return
+	}
+	if err != nil {
+		log.Printf("ERRO: %v", err)
+		http.Error(w, "Erro ao registrar bônus", http.StatusInternalServerError)
+		return
+	}
+
+	if duplicate {
+		if outcome.User != req.User || outcome.Bonus != req.Bonus {
+			log.Printf("[Dedup] Venda %s já processada com outro bônus (%s: %d)", req.TransactionID, outcome.User, outcome.Bonus)
+			http.Error(w, "transactionID já usado com outro usuário ou valor de bônus", http.StatusUnprocessableEntity)
+			return
+		}
+		log.Printf("[Dedup] Bônus da venda %s já processado em %s, devolvendo resultado original", req.TransactionID, outcome.ProcessedAt.Format(time.RFC3339))
+		w.Header().Set(idempotentReplayedHeader, "true")
+	}
+	writeBonusResponse(w, outcome.StatusCode, outcome.Message)
+}
+
+func writeBonusResponse(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, BonusResponse{Message: message})
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+type ReserveRequest struct {
+	ReservationID string `json:"reservationID"` // chave de idempotência, gerada pelo chamador
+	User          string `json:"user"`
+	Points        int    `json:"points"`
+}
+
+type RedeemRequest struct {
+	TransactionID string `json:"transactionID"` // venda paga com os pontos
+}
+
+// Responde o erro de uma operação de resgate com o status correspondente
+func writeRedemptionError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrInsufficientPoints):
+		status = http.StatusUnprocessableEntity
+	case errors.Is(err, ErrReservationNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrReservationConflict):
+		status = http.StatusConflict
+	default:
+		log.Printf("ERRO: %v", err)
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// POST /redemptions
+func reserveHandler(w http.ResponseWriter, r *http.Request) {
+	var req ReserveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Erro ao decodificar JSON", http.StatusBadRequest)
+		return
+	}
+	if req.ReservationID == "" || req.User == "" || req.Points <= 0 {
+		http.Error(w, "reservationID e user são obrigatórios e points deve ser maior que 0", http.StatusBadRequest)
+		return
+	}
+
+	reservation, existing, err := ledger.Reserve(req.ReservationID, req.User, req.Points)
+	if err != nil {
+		log.Printf("[Resgate] Reserva %s de %d pontos para usuário %s recusada: %v", req.ReservationID, req.Points, req.User, err)
+		writeRedemptionError(w, err)
+		return
+	}
+
+	if existing {
+		w.Header().Set(idempotentReplayedHeader, "true")
+		writeJSON(w, http.StatusOK, reservation)
+		return
+	}
+	log.Printf("[Resgate] Reservados %d pontos do usuário %s (reserva %s)", reservation.Points, reservation.User, reservation.ID)
+	writeJSON(w, http.StatusCreated, reservation)
+}
+
+// GET /redemptions/{id}
+func getReservationHandler(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := ledger.Reservation(r.PathValue("id"))
+	if !ok {
+		http.Error(w, ErrReservationNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, reservation)
+}
+
+// POST /redemptions/{id}/confirm
+func redeemHandler(w http.ResponseWriter, r *http.Request) {
+	var req RedeemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Erro ao decodificar JSON", http.StatusBadRequest)
+		return
+	}
+
+	reservation, err := ledger.Redeem(r.PathValue("id"), req.TransactionID)
+	if err != nil {
+		writeRedemptionError(w, err)
+		return
+	}
+	log.Printf("[Resgate] Debitados %d pontos do usuário %s (reserva %s, venda %s)", reservation.Points, reservation.User, reservation.ID, reservation.TransactionID)
+	writeJSON(w, http.StatusOK, reservation)
+}
+
+// POST /redemptions/{id}/release
+func releaseHandler(w http.ResponseWriter, r *http.Request) {
+	reservation, err := ledger.Release(r.PathValue("id"))
+	if err != nil {
+		writeRedemptionError(w, err)
+		return
+	}
+	log.Printf("[Resgate] Reserva %s do usuário %s liberada (%d pontos)", reservation.ID, reservation.User, reservation.Points)
+	writeJSON(w, http.StatusOK, reservation)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ReversalReversed = "REVERSED"  // todo o bônus foi estornado
+	ReversalPartial  = "PARTIAL"   // saldo insuficiente: parte do bônus não foi estornada (shortfall)
+	ReversalNoCredit = "NO_CREDIT" // o bônus ainda não tinha sido creditado; se chegar depois, é ignorado
+)
+
+// Estorno do bônus de uma venda cancelada, identificado pelo transactionID da venda.
+// Points é o bônus creditado pela venda; Expired, a parte dele que já tinha expirado (e não é
+// estornada de novo); Reversed, o que saiu do saldo; Shortfall, o que faltou por saldo insuficiente.
+type Reversal struct {
+	TransactionID string    `json:"transactionID"`
+	User          string    `json:"user"`
+	Status        string    `json:"status"`
+	Points        int       `json:"points"`
+	Expired       int       `json:"expired"`
+	Reversed      int       `json:"reversed"`
+	Shortfall     int       `json:"shortfall"`
+	Reason        string    `json:"reason,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// Reverse estorna o bônus creditado pela venda transactionID. É idempotente pelo transactionID:
+// repetir a chamada devolve o estorno existente (com existing=true), desde que o usuário seja o mesmo.
+//
+// Pontos reservados para resgates não são estornados. Se o saldo disponível não cobre o bônus
+// (os pontos já foram resgatados), estorna o que houver e registra o restante em Shortfall.
+// Se o bônus ainda não foi creditado, o estorno é registrado assim mesmo e o crédito que chegar
+// depois é recusado (ErrBonusReversed). Um bônus creditado para outro usuário não é estornado nem
+// registrado (ErrReversalConflict), para não bloquear o estorno correto da venda.
+func (l *Ledger) Reverse(transactionID, user, reason string) (reversal Reversal, existing bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if reversal, ok := l.reversals[transactionID]; ok {
+		if reversal.User != user {
+			return reversal, true, fmt.Errorf("%w: venda %s estornada para %s", ErrReversalConflict, transactionID, reversal.User)
+		}
+		return reversal, true, nil
+	}
+
+	reversal = Reversal{
+		TransactionID: transactionID,
+		User:          user,
+		Status:        ReversalNoCredit,
+		Reason:        reason,
+		CreatedAt:     time.Now(),
+	}
+	record := ledgerRecord{Reversal: &reversal}
+
+	lot := l.creditLots[transactionID]
+	if lot != nil && lot.User != user {
+		return Reversal{}, false, fmt.Errorf("%w: bônus da venda %s creditado para %s", ErrReversalConflict, transactionID, lot.User)
+	}
+	if lot != nil {
+		due := lot.Points - lot.Expired
+		available := max(l.balances[user]-l.reserved[user], 0)
+
+		reversal.Points = lot.Points
+		reversal.Expired = lot.Expired
+		reversal.Reversed = min(due, available)
+		reversal.Shortfall = due - reversal.Reversed
+		reversal.Status = ReversalReversed
+		if reversal.Shortfall > 0 {
+			reversal.Status = ReversalPartial
+		}
+
+		if reversal.Reversed > 0 {
+			record.Entry = l.newEntry(user, EntryReversal, -reversal.Reversed, transactionID)
+			record.Entry.LotID = lot.ID
+			reversal.CreatedAt = record.Entry.CreatedAt
+		}
+	}
+
+	if err := l.write(record); err != nil {
+		return Reversal{}, false, err
+	}
+	return reversal, false, nil
+}
+
+func (l *Ledger) Reversal(transactionID string) (Reversal, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	reversal, ok := l.reversals[transactionID]
+	return reversal, ok
+}
+
+// Reversals retorna uma página dos estornos com o status informado (todos, se vazio), do mais recente para o mais antigo
+func (l *Ledger) Reversals(status string, limit, offset int) (page []Reversal, total int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var reversals []Reversal
+	for _, reversal := range l.reversals {
+		if status == "" || reversal.Status == status {
+			reversals = append(reversals, reversal)
+		}
+	}
+	slices.SortFunc(reversals, func(a, b Reversal) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), strings.Compare(a.TransactionID, b.TransactionID))
+	})
+
+	total = len(reversals)
+	page = append([]Reversal{}, paginate(reversals, limit, offset)...)
+	return page, total
+}
+
+type ReversalRequest struct {
+	TransactionID string `json:"transactionID"` // venda cancelada (chave de idempotência)
+	User          string `json:"user"`
+	Reason        string `json:"reason,omitempty"`
+}
+
+type ReversalListResponse struct {
+	Reversals []Reversal `json:"reversals"`
+	Total     int        `json:"total"`
+	Limit     int        `json:"limit"`
+	Offset    int        `json:"offset"`
+}
+
+// POST /reversals
+func reverseHandler(w http.ResponseWriter, r *http.Request) {
+	var req ReversalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Erro ao decodificar JSON", http.StatusBadRequest)
+		return
+	}
+	if req.TransactionID == "" || req.User == "" {
+		http.Error(w, "transactionID e user são obrigatórios", http.StatusBadRequest)
+		return
+	}
+
+	reversal, existing, err := ledger.Reverse(req.TransactionID, req.User, req.Reason)
+	if errors.Is(err, ErrReversalConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERRO: %v", err)
+		http.Error(w, "Erro ao registrar estorno", http.StatusInternalServerError)
+		return
+	}
+
+	if existing {
+		w.Header().Set(idempotentReplayedHeader, "true")
+		writeJSON(w, http.StatusOK, reversal)
+		return
+	}
+
+	switch reversal.Status {
+	case ReversalNoCredit:
+		log.Printf("[Estorno] Venda %s do usuário %s ainda sem bônus creditado, o bônus será ignorado se chegar", reversal.TransactionID, reversal.User)
+	case ReversalPartial:
+		log.Printf("[Estorno] AVISO: saldo insuficiente do usuário %s, estornados %d de %d pontos da venda %s (faltaram %d)",
+			reversal.User, reversal.Reversed, reversal.Points-reversal.Expired, reversal.TransactionID, reversal.Shortfall)
+	default:
+		log.Printf("[Estorno] Estornados %d pontos do usuário %s (venda %s)", reversal.Reversed, reversal.User, reversal.TransactionID)
+	}
+	if reversal.Status != ReversalNoCredit {
+		tiers.Evaluate(reversal.User)
+	}
+	writeJSON(w, http.StatusCreated, reversal)
+}
+
+// GET /reversals/{transactionID}
+func getReversalHandler(w http.ResponseWriter, r *http.Request) {
+	reversal, ok := ledger.Reversal(r.PathValue("transactionID"))
+	if !ok {
+		http.Error(w, "estorno não encontrado", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, reversal)
+}
+
+// GET /reversals?status=PARTIAL&limit=20&offset=0
+func listReversalsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, err := parseQueryInt(query.Get("limit"), defaultPageLimit)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		http.Error(w, fmt.Sprintf("limit deve ser um número entre 1 e %d", maxPageLimit), http.StatusBadRequest)
+		return
+	}
+	offset, err := parseQueryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		http.Error(w, "offset deve ser um número maior ou igual a 0", http.StatusBadRequest)
+		return
+	}
+	status := strings.ToUpper(query.Get("status"))
+	switch status {
+	case "", ReversalReversed, ReversalPartial, ReversalNoCredit:
+	default:
+		http.Error(w, fmt.Sprintf("status deve ser %s, %s ou %s", ReversalReversed, ReversalPartial, ReversalNoCredit), http.StatusBadRequest)
+		return
+	}
+
+	reversals, total := ledger.Reversals(status, limit, offset)
+	writeJSON(w, http.StatusOK, ReversalListResponse{
+		Reversals: reversals,
+		Total:     total,
+		Limit:     limit,
+		Offset:    offset,
+	})
+}
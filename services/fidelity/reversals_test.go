@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReverse(t *testing.T) {
+	tests := []struct {
+		name      string
+		expired   bool // o lote do bônus venceu antes do estorno
+		reserved  int  // pontos presos em reserva de resgate no momento do estorno
+		user      string
+		wantErr   error
+		want      Reversal
+		wantSaldo int
+	}{
+		{
+			name: "estorno completo", user: "ana",
+			want:      Reversal{Status: ReversalReversed, Points: 100, Reversed: 100},
+			wantSaldo: 0,
+		},
+		{
+			name: "saldo reservado gera shortfall", user: "ana", reserved: 60,
+			want:      Reversal{Status: ReversalPartial, Points: 100, Reversed: 40, Shortfall: 60},
+			wantSaldo: 60,
+		},
+		{
+			name: "bônus já expirado não é estornado de novo", user: "ana", expired: true,
+			want:      Reversal{Status: ReversalReversed, Points: 100, Expired: 100},
+			wantSaldo: 0,
+		},
+		{
+			name: "usuário errado", user: "bruno",
+			wantErr:   ErrReversalConflict,
+			wantSaldo: 100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expiry := time.Hour
+			if tt.expired {
+				expiry = -time.Hour
+			}
+			l := openTestLedger(t, t.TempDir(), expiry)
+			credit, err := l.Credit("ana", 100, "venda-1")
+			if err != nil {
+				t.Fatalf("Credit: %v", err)
+			}
+			if tt.expired {
+				if _, ok, _, err := l.expireLot(credit.Seq, time.Now()); !ok || err != nil {
+					t.Fatalf("expireLot: ok=%v err=%v", ok, err)
+				}
+			}
+			if tt.reserved > 0 {
+				if _, _, err := l.Reserve("reserva-1", "ana", tt.reserved); err != nil {
+					t.Fatalf("Reserve: %v", err)
+				}
+			}
+
+			reversal, _, err := l.Reverse("venda-1", tt.user, "compra cancelada")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Reverse err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil {
+				got := Reversal{Status: reversal.Status, Points: reversal.Points, Expired: reversal.Expired, Reversed: reversal.Reversed, Shortfall: reversal.Shortfall}
+				if got != tt.want {
+					t.Fatalf("Reverse = %+v, want %+v", got, tt.want)
+				}
+			}
+			if balance, _, _ := l.Balance("ana"); balance != tt.wantSaldo {
+				t.Fatalf("saldo = %d, want %d", balance, tt.wantSaldo)
+			}
+		})
+	}
+}
+
+func TestReverseWithWrongUserDoesNotBlockTheSale(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLedger(t, dir, time.Hour)
+	if _, err := l.Credit("ana", 100, "venda-1"); err != nil {
+		t.Fatalf("Credit: %v", err)
+	}
+	// o lote do bônus é encontrado pelo transactionID também depois de reabrir o ledger
+	l.Close()
+	l = openTestLedger(t, dir, time.Hour)
+
+	if _, _, err := l.Reverse("venda-1", "bruno", ""); !errors.Is(err, ErrReversalConflict) {
+		t.Fatalf("Reverse com usuário errado err = %v, want %v", err, ErrReversalConflict)
+	}
+	if _, ok := l.Reversal("venda-1"); ok {
+		t.Fatalf("estorno recusado ficou registrado")
+	}
+
+	reversal, existing, err := l.Reverse("venda-1", "ana", "")
+	if err != nil || existing || reversal.Status != ReversalReversed || reversal.Reversed != 100 {
+		t.Fatalf("Reverse com o usuário certo = %+v, existing=%v, err=%v", reversal, existing, err)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fsousabt/shared/journal"
+)
+
+const (
+	TierStandard = "STANDARD"
+	TierSilver   = "SILVER"
+	TierGold     = "GOLD"
+	TierPlatinum = "PLATINUM"
+)
+
+// Categoria atual do usuário, gravada a cada mudança
+type TierStatus struct {
+	User         string    `json:"user"`
+	Tier         string    `json:"tier"`
+	Since        time.Time `json:"since"`
+	WindowPoints int       `json:"windowPoints"` // pontos na janela no momento da mudança
+}
+
+type TierResponse struct {
+	User             string    `json:"user"`
+	Tier             string    `json:"tier"`
+	Since            time.Time `json:"since,omitzero"`
+	WindowPoints     int       `json:"windowPoints"`
+	Window           string    `json:"window"`
+	NextTier         string    `json:"nextTier,omitempty"`
+	PointsToNextTier int       `json:"pointsToNextTier,omitempty"`
+}
+
+// Acompanha a categoria (tier) de cada usuário a partir dos pontos creditados na janela móvel.
+// A categoria é reavaliada a cada crédito e periodicamente, para rebaixar usuários cujos pontos
+// saíram da janela. As mudanças são gravadas em um journal.
+type TierTracker struct {
+	mu sync.Mutex
+
+	config        TierConfig
+	ledger        *Ledger
+	statuses      map[string]TierStatus
+	journal       *journal.Journal
+	snapshotEvery int
+	done          chan struct{}
+}
+
+func NewTierTracker(c Config, ledger *Ledger) (*TierTracker, error) {
+	journal, err := journal.Open(c.DataDir, "tiers", c.Journal)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &TierTracker{
+		config:        c.Tier,
+		ledger:        ledger,
+		statuses:      make(map[string]TierStatus),
+		journal:       journal,
+		snapshotEvery: max(c.SnapshotEvery, 1),
+		done:          make(chan struct{}),
+	}
+
+	err = journal.Load(func(data []byte) error {
+		var status TierStatus
+		if err := json.Unmarshal(data, &status); err != nil {
+			return err
+		}
+		t.statuses[status.User] = status
+		return nil
+	})
+	if err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("falha ao recuperar categorias: %w", err)
+	}
+
+	log.Printf("[Tier] %d usuário(s) com categoria recuperado(s)", len(t.statuses))
+	return t, nil
+}
+
+// Start reavalia todos os usuários (a janela pode ter andado com o serviço fora do ar)
+// e depois continua reavaliando a cada EvaluateInterval
+func (t *TierTracker) Start() {
+	t.evaluateAll()
+	go func() {
+		ticker := time.NewTicker(max(t.config.EvaluateInterval, time.Second))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				t.evaluateAll()
+			case <-t.done:
+				return
+			}
+		}
+	}()
+}
+
+func (t *TierTracker) evaluateAll() {
+	for _, user := range t.ledger.Users() {
+		t.Evaluate(user)
+	}
+}
+
+func (t *TierTracker) tierFor(points int) string {
+	switch {
+	case points >= t.config.Platinum:
+		return TierPlatinum
+	case points >= t.config.Gold:
+		return TierGold
+	case points >= t.config.Silver:
+		return TierSilver
+	}
+	return TierStandard
+}
+
+// Evaluate recalcula a categoria do usuário, gravando a mudança se houver
+func (t *TierTracker) Evaluate(user string) (status TierStatus, windowPoints int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	windowPoints = t.ledger.Accrued(user, now.Add(-t.config.Window))
+	tier := t.tierFor(windowPoints)
+
+	status, ok := t.statuses[user]
+	if !ok {
+		status = TierStatus{User: user, Tier: TierStandard}
+	}
+	if status.Tier == tier {
+		return status, windowPoints
+	}
+
+	log.Printf("[Tier] Usuário %s: %s -> %s (%d pontos nos últimos %v)", user, status.Tier, tier, windowPoints, t.config.Window)
+	status = TierStatus{User: user, Tier: tier, Since: now, WindowPoints: windowPoints}
+	t.statuses[user] = status
+	if err := t.journal.Append(status); err != nil {
+		log.Printf("[Tier] ERRO: falha ao gravar categoria do usuário %s: %v", user, err)
+	}
+	if t.journal.Records() >= t.snapshotEvery {
+		t.snapshot()
+	}
+	return status, windowPoints
+}
+
+// Deve ser chamado com t.mu travado
+func (t *TierTracker) snapshot() {
+	records := make([]any, 0, len(t.statuses))
+	for _, status := range t.statuses {
+		records = append(records, status)
+	}
+	if err := t.journal.Snapshot(records); err != nil {
+		log.Printf("[Tier] ERRO: falha ao gravar snapshot: %v", err)
+	}
+}
+
+func (t *TierTracker) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	select {
+	case <-t.done:
+	default:
+		close(t.done)
+	}
+	t.snapshot()
+	return t.journal.Close()
+}
+
+var tiers *TierTracker
+
+// GET /users/{user}/tier
+func tierHandler(w http.ResponseWriter, r *http.Request) {
+	status, windowPoints := tiers.Evaluate(r.PathValue("user"))
+
+	response := TierResponse{
+		User:         status.User,
+		Tier:         status.Tier,
+		Since:        status.Since,
+		WindowPoints: windowPoints,
+		Window:       tiers.config.Window.String(),
+	}
+	for _, next := range []struct {
+		tier   string
+		points int
+	}{{TierSilver, tiers.config.Silver}, {TierGold, tiers.config.Gold}, {TierPlatinum, tiers.config.Platinum}} {
+		if windowPoints < next.points {
+			response.NextTier = next.tier
+			response.PointsToNextTier = next.points - windowPoints
+			break
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fsousabt/shared/journal"
+	"github.com/google/uuid"
+)
+
+// Tipos de entrega ao Fidelity que passam pela fila. Entregas gravadas antes dos estornos existirem não têm kind.
+const (
+	DeliveryBonus    = "bonus"
+	DeliveryReversal = "reversal" // estorno do bônus de uma venda cancelada (Request.Bonus não é usado)
+)
+
+type PendingBonus struct {
+	ID            uuid.UUID       `json:"id"`
+	Kind          string          `json:"kind,omitempty"`
+	Request       FidelityRequest `json:"request"`
+	Reason        string          `json:"reason,omitempty"` // motivo do estorno
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"lastError,omitempty"`
+	EnqueuedAt    time.Time       `json:"enqueuedAt"`
+	LastAttemptAt time.Time       `json:"lastAttemptAt,omitzero"`
+	NextAttemptAt time.Time       `json:"nextAttemptAt,omitzero"`
+}
+
+const (
+	bonusOpEnqueue    = "enqueue"
+	bonusOpAttempt    = "attempt"
+	bonusOpAck        = "ack"
+	bonusOpDeadLetter = "dead-letter"
+)
+
+type OverflowPolicy string
+
+const (
+	OverflowReject     OverflowPolicy = "reject"      // recusa o bônus novo
+	OverflowSpill      OverflowPolicy = "spill"       // guarda o excedente só em disco
+	OverflowDropOldest OverflowPolicy = "drop-oldest" // move o bônus mais antigo para o dead-letter
+)
+
+var ErrBonusQueueFull = errors.New("fila de bônus pendentes cheia")
+
+// Menor espera de um worker quando o circuito do Fidelity está aberto
+const circuitOpenPause = time.Second
+
+// Registro do journal da fila. Enqueue carrega o bônus completo; attempt e ack só o id.
+type bonusQueueRecord struct {
+	Op    string        `json:"op"`
+	ID    uuid.UUID     `json:"id"`
+	Bonus *PendingBonus `json:"bonus,omitempty"`
+	Error string        `json:"error,omitempty"`
+	At    time.Time     `json:"at"`
+	Next  time.Time     `json:"next,omitzero"` // próxima tentativa (attempt)
+}
+
+type BonusQueueStatus struct {
+	Policy   OverflowPolicy `json:"policy"`
+	Capacity int            `json:"capacity"`
+	Workers  int            `json:"workers"`
+	Depth    int            `json:"depth"`
+	InMemory int            `json:"inMemory"`
+	Spilled  int            `json:"spilled"`
+
+	Enqueued       int64      `json:"enqueued"`
+	Delivered      int64      `json:"delivered"`
+	Rejected       int64      `json:"rejected"`
+	SpilledTotal   int64      `json:"spilledTotal"`
+	DroppedOldest  int64      `json:"droppedOldest"`
+	DeadLetters    int        `json:"deadLetters"`
+	LastOverflowAt *time.Time `json:"lastOverflowAt,omitempty"`
+
+	Fidelity *FidelityHealthStatus `json:"fidelity,omitempty"`
+}
+
+// Fila de bônus pendentes (que falharam no envio ao Fidelity) gravada em um journal:
+// bônus ainda não confirmados (ack) são recuperados quando o serviço reinicia.
+// No máximo capacity bônus ficam em memória; o excedente segue a política de overflow.
+// Enqueue nunca bloqueia.
+//
+// Cada worker cuida de um subconjunto dos usuários (hash do usuário), então os bônus de um usuário
+// são entregues na ordem em que chegaram. Cada bônus tem seu próprio horário da próxima tentativa:
+// um bônus esperando o backoff só segura os bônus seguintes do mesmo usuário.
+type PendingBonusQueue struct {
+	mu sync.Mutex
+
+	capacity      int
+	maxAttempts   int
+	workers       int
+	backoff       *RetryPolicy
+	timeout       time.Duration // prazo de cada entrega
+	policy        OverflowPolicy
+	pending       map[uuid.UUID]*PendingBonus
+	order         []uuid.UUID // ordem de chegada
+	spill         *bonusSpill
+	deadLetters   *DeadLetterStore
+	journal       *journal.Journal
+	snapshotEvery int
+
+	enqueued       int64
+	delivered      int64
+	rejected       int64
+	spilledTotal   int64
+	droppedOldest  int64
+	lastOverflowAt time.Time
+
+	notify []chan struct{} // um por worker
+	done   chan struct{}
+}
+
+func NewPendingBonusQueue(c BonusQueueConfig, deadLetters *DeadLetterStore) (*PendingBonusQueue, error) {
+	journal, err := journal.Open(c.Dir, "bonus-queue", c.Journal)
+	if err != nil {
+		return nil, err
+	}
+	spill, err := openBonusSpill(c.Dir)
+	if err != nil {
+		journal.Close()
+		return nil, err
+	}
+
+	q := &PendingBonusQueue{
+		capacity:      max(c.Capacity, 1),
+		maxAttempts:   max(c.MaxAttempts, 1),
+		workers:       max(c.Workers, 1),
+		backoff:       NewRetryPolicy("fidelity-bonus-queue", c.Backoff, nil),
+		timeout:       max(c.DeliveryTimeout, time.Millisecond),
+		policy:        c.Overflow,
+		pending:       make(map[uuid.UUID]*PendingBonus),
+		spill:         spill,
+		deadLetters:   deadLetters,
+		journal:       journal,
+		snapshotEvery: max(c.SnapshotEvery, 1),
+		done:          make(chan struct{}),
+	}
+	for range q.workers {
+		q.notify = append(q.notify, make(chan struct{}, 1))
+	}
+
+	err = journal.Load(func(data []byte) error {
+		var record bonusQueueRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		q.apply(record)
+		return nil
+	})
+	if err != nil {
+		journal.Close()
+		spill.Close()
+		return nil, fmt.Errorf("falha ao recuperar fila de bônus: %w", err)
+	}
+
+	// Na recuperação todos os bônus pendentes são lidos do journal; o que passar da
+	// capacidade volta para o arquivo de spill (qualquer que seja a política, nada é descartado aqui).
+	recovered := len(q.order)
+	for len(q.order) > q.capacity {
+		id := q.order[q.capacity]
+		if err := spill.Push(*q.pending[id]); err != nil {
+			journal.Close()
+			spill.Close()
+			return nil, err
+		}
+		delete(q.pending, id)
+		q.order = slices.Delete(q.order, q.capacity, q.capacity+1)
+	}
+
+	if recovered > 0 {
+		log.Printf("[pendingBonusQueue] %d bônus pendente(s) recuperado(s) do journal (%d em disco)", recovered, spill.Len())
+		q.signal()
+	}
+	return q, nil
+}
+
+// Deve ser chamado com q.mu travado (ou durante a recuperação)
+func (q *PendingBonusQueue) apply(record bonusQueueRecord) {
+	switch record.Op {
+	case bonusOpEnqueue:
+		if record.Bonus == nil {
+			return
+		}
+		bonus := *record.Bonus
+		if _, ok := q.pending[bonus.ID]; !ok {
+			q.order = append(q.order, bonus.ID)
+		}
+		q.pending[bonus.ID] = &bonus
+	case bonusOpAttempt:
+		bonus, ok := q.pending[record.ID]
+		if !ok {
+			return
+		}
+		bonus.Attempts++
+		bonus.LastError = record.Error
+		bonus.LastAttemptAt = record.At
+		bonus.NextAttemptAt = record.Next
+	case bonusOpAck, bonusOpDeadLetter:
+		if _, ok := q.pending[record.ID]; !ok {
+			return
+		}
+		delete(q.pending, record.ID)
+		q.order = slices.DeleteFunc(q.order, func(id uuid.UUID) bool { return id == record.ID })
+	}
+}
+
+// Deve ser chamado com q.mu travado. O registro é aplicado em memória mesmo que a escrita
+// no journal falhe; nesse caso o erro é retornado para quem chamou.
+func (q *PendingBonusQueue) record(record bonusQueueRecord) error {
+	q.apply(record)
+	return q.append(record)
+}
+
+// Deve ser chamado com q.mu travado
+func (q *PendingBonusQueue) append(record bonusQueueRecord) error {
+	err := q.journal.Append(record)
+	if err != nil {
+		err = fmt.Errorf("falha ao gravar %s do bônus %s: %w", record.Op, record.ID, err)
+	}
+	if q.journal.Records() >= q.snapshotEvery {
+		q.snapshot()
+	}
+	return err
+}
+
+// Deve ser chamado com q.mu travado
+func (q *PendingBonusQueue) snapshot() {
+	records := make([]any, 0, len(q.order))
+	for _, id := range q.order {
+		records = append(records, bonusQueueRecord{Op: bonusOpEnqueue, ID: id, Bonus: q.pending[id], At: q.pending[id].EnqueuedAt})
+	}
+	spilled, err := q.spill.Remaining()
+	if err != nil {
+		log.Printf("[pendingBonusQueue] ERRO: snapshot adiado, falha ao ler bônus em disco: %v", err)
+		return
+	}
+	for _, bonus := range spilled {
+		records = append(records, bonusQueueRecord{Op: bonusOpEnqueue, ID: bonus.ID, Bonus: &bonus, At: bonus.EnqueuedAt})
+	}
+	if err := q.journal.Snapshot(records); err != nil {
+		log.Printf("[pendingBonusQueue] ERRO: falha ao gravar snapshot da fila: %v", err)
+	}
+}
+
+func (q *PendingBonusQueue) signal() {
+	for _, notify := range q.notify {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Worker responsável pelos bônus do usuário
+func (q *PendingBonusQueue) partition(user string) int {
+	h := fnv.New32a()
+	h.Write([]byte(user))
+	return int(h.Sum32() % uint32(q.workers))
+}
+
+// Enqueue adiciona o bônus à fila sem bloquear. Com a fila cheia e a política "reject",
+// retorna ErrBonusQueueFull.
+func (q *PendingBonusQueue) Enqueue(request FidelityRequest) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.enqueue(&PendingBonus{ID: uuid.New(), Kind: DeliveryBonus, Request: request, EnqueuedAt: time.Now()})
+}
+
+// EnqueueReversal adiciona à fila o estorno do bônus da venda transactionID. Ele passa pela mesma fila
+// (e pelo mesmo worker) que os bônus do usuário, então é entregue depois de um bônus ainda pendente da venda.
+func (q *PendingBonusQueue) EnqueueReversal(user string, transactionID uuid.UUID, reason string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.enqueue(&PendingBonus{
+		ID:         uuid.New(),
+		Kind:       DeliveryReversal,
+		Request:    FidelityRequest{User: user, TransactionID: transactionID.String()},
+		Reason:     reason,
+		EnqueuedAt: time.Now(),
+	})
+}
+
+// Redrive devolve à fila um bônus do dead-letter, com o contador de tentativas zerado e pronto para envio
+// (sem esperar o backoff agendado pela última tentativa)
+func (q *PendingBonusQueue) Redrive(letter DeadLetter) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	bonus := letter.Bonus
+	bonus.Attempts = 0
+	bonus.LastError = ""
+	bonus.LastAttemptAt = time.Time{}
+	bonus.NextAttemptAt = time.Time{}
+	bonus.EnqueuedAt = time.Now()
+	return q.enqueue(&bonus)
+}
+
+// Deve ser chamado com q.mu travado
+func (q *PendingBonusQueue) enqueue(bonus *PendingBonus) error {
+	now := bonus.EnqueuedAt
+	request := bonus.Request
+	record := bonusQueueRecord{Op: bonusOpEnqueue, ID: bonus.ID, Bonus: bonus, At: now}
+
+	// Bônus só ficam em disco com a memória cheia, então a fila está cheia quando o total
+	// (memória + disco) atinge a capacidade; com "spill" os novos vão para o fim do arquivo.
+	if len(q.order)+q.spill.Len() >= q.capacity {
+		q.lastOverflowAt = now
+		switch q.policy {
+		case OverflowReject:
+			q.rejected++
+			log.Printf("[pendingBonusQueue] OVERFLOW: fila cheia (%d), bônus de %d para usuário %s recusado", q.capacity, request.Bonus, request.User)
+			return ErrBonusQueueFull
+		case OverflowSpill:
+			if err := q.spill.Push(*bonus); err != nil {
+				q.rejected++
+				return fmt.Errorf("%w: %v", ErrBonusQueueFull, err)
+			}
+			q.spilledTotal++
+			q.enqueued++
+			log.Printf("[pendingBonusQueue] OVERFLOW: fila cheia (%d), bônus de %d para usuário %s guardado em disco (%d em disco)", q.capacity, request.Bonus, request.User, q.spill.Len())
+			return q.append(record)
+		case OverflowDropOldest:
+			q.dropOldest()
+		}
+	}
+
+	q.enqueued++
+	err := q.record(record)
+	q.signal()
+	return err
+}
+
+// Deve ser chamado com q.mu travado
+func (q *PendingBonusQueue) dropOldest() {
+	if len(q.order) == 0 {
+		return
+	}
+	oldest := *q.pending[q.order[0]]
+
+	q.moveToDeadLetter(oldest, "descartado por overflow da fila (drop-oldest)")
+	q.droppedOldest++
+	log.Printf("[pendingBonusQueue] OVERFLOW: fila cheia (%d), bônus mais antigo (%s, usuário %s) movido para o dead-letter", q.capacity, oldest.ID, oldest.Request.User)
+}
+
+// Deve ser chamado com q.mu travado.
+// O bônus é gravado no dead-letter antes de sair da fila: uma queda entre as duas escritas
+// deixa o bônus nos dois lugares, mas nunca o perde.
+func (q *PendingBonusQueue) moveToDeadLetter(bonus PendingBonus, reason string) {
+	if err := q.deadLetters.Add(bonus, reason); err != nil {
+		log.Printf("[pendingBonusQueue] ERRO: %v", err)
+	}
+	if err := q.record(bonusQueueRecord{Op: bonusOpDeadLetter, ID: bonus.ID, At: time.Now()}); err != nil {
+		log.Printf("[pendingBonusQueue] ERRO: %v", err)
+	}
+}
+
+// Deve ser chamado com q.mu travado. Traz de volta para a memória os bônus guardados em disco.
+func (q *PendingBonusQueue) refill() {
+	for len(q.order) < q.capacity {
+		bonus, ok, err := q.spill.Pop()
+		if err != nil {
+			log.Printf("[pendingBonusQueue] ERRO: %v", err)
+			return
+		}
+		if !ok {
+			return
+		}
+		q.pending[bonus.ID] = &bonus
+		q.order = append(q.order, bonus.ID)
+	}
+}
+
+// Next bloqueia até o worker ter um bônus pronto para envio e o retorna sem removê-lo da fila:
+// ele só sai da fila com Ack. Só o bônus mais antigo de cada usuário pode ser enviado.
+// Retorna ok=false quando a fila é fechada.
+func (q *PendingBonusQueue) Next(worker int) (bonus PendingBonus, ok bool) {
+	for {
+		bonus, wait, ok := q.ready(worker)
+		if ok {
+			return bonus, true
+		}
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case <-q.notify[worker]:
+		case <-timeout:
+		case <-q.done:
+			return PendingBonus{}, false
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// pause segura o worker por d; retorna false se a fila foi fechada durante a espera
+func (q *PendingBonusQueue) pause(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-q.done:
+		return false
+	}
+}
+
+// Primeiro bônus do worker pronto para envio, ou quanto tempo falta para o próximo ficar pronto
+// (wait=0 se o worker não tem bônus pendentes)
+func (q *PendingBonusQueue) ready(worker int) (bonus PendingBonus, wait time.Duration, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	for _, id := range q.order {
+		pending := q.pending[id]
+		user := pending.Request.User
+		if seen[user] || q.partition(user) != worker {
+			continue
+		}
+		seen[user] = true
+
+		if !pending.NextAttemptAt.After(now) {
+			return *pending, 0, true
+		}
+		if until := pending.NextAttemptAt.Sub(now); wait == 0 || until < wait {
+			wait = until
+		}
+	}
+	return PendingBonus{}, wait, false
+}
+
+// Attempt registra uma tentativa de entrega que falhou e agenda a próxima com backoff exponencial.
+// Ao atingir o limite de tentativas o bônus vai para o dead-letter (deadLettered=true).
+func (q *PendingBonusQueue) Attempt(id uuid.UUID, attemptErr error) (next time.Time, deadLettered bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	bonus, ok := q.pending[id]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	var prev time.Duration
+	if !bonus.LastAttemptAt.IsZero() {
+		prev = bonus.NextAttemptAt.Sub(bonus.LastAttemptAt)
+	}
+	now := time.Now()
+	next = now.Add(q.backoff.backoff(bonus.Attempts+1, prev))
+
+	err = q.record(bonusQueueRecord{Op: bonusOpAttempt, ID: id, Error: attemptErr.Error(), At: now, Next: next})
+	if bonus.Attempts < q.maxAttempts {
+		return next, false, err
+	}
+	q.moveToDeadLetter(*bonus, fmt.Sprintf("limite de %d tentativas de entrega atingido", q.maxAttempts))
+	q.refill()
+	q.signal()
+	return time.Time{}, true, err
+}
+
+// Ack remove da fila um bônus entregue ao Fidelity
+func (q *PendingBonusQueue) Ack(id uuid.UUID) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	err := q.record(bonusQueueRecord{Op: bonusOpAck, ID: id, At: time.Now()})
+	q.delivered++
+	q.refill()
+	q.signal()
+	return err
+}
+
+// Len retorna a quantidade de bônus pendentes, em memória e em disco
+func (q *PendingBonusQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.order) + q.spill.Len()
+}
+
+func (q *PendingBonusQueue) Status() BonusQueueStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	status := BonusQueueStatus{
+		Policy:        q.policy,
+		Capacity:      q.capacity,
+		Workers:       q.workers,
+		Depth:         len(q.order) + q.spill.Len(),
+		InMemory:      len(q.order),
+		Spilled:       q.spill.Len(),
+		Enqueued:      q.enqueued,
+		Delivered:     q.delivered,
+		Rejected:      q.rejected,
+		SpilledTotal:  q.spilledTotal,
+		DroppedOldest: q.droppedOldest,
+		DeadLetters:   q.deadLetters.Len(),
+	}
+	if !q.lastOverflowAt.IsZero() {
+		lastOverflowAt := q.lastOverflowAt
+		status.LastOverflowAt = &lastOverflowAt
+	}
+	return status
+}
+
+func (q *PendingBonusQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	default:
+		close(q.done)
+	}
+
+	q.snapshot()
+	q.spill.Close()
+	return q.journal.Close()
+}
+
+var pendingBonusQueue *PendingBonusQueue
+
+func bonusQueueHandler(w http.ResponseWriter, r *http.Request) {
+	status := pendingBonusQueue.Status()
+	fidelity := fidelityHealth.Status()
+	status.Fidelity = &fidelity
+	writeJSON(w, http.StatusOK, status)
+}
+
+func processPendingBonus(queue *PendingBonusQueue, worker int) {
+	log.Printf("[processPendingBonus] (worker %d) Iniciando Worker de processamento assincrono de bonus", worker)
+	for {
+		// Espera o Fidelity estar no ar (e, na retomada, a vez deste worker)
+		if !fidelityHealth.Acquire() {
+			return
+		}
+		bonus, ok := queue.Next(worker)
+		if !ok {
+			return
+		}
+
+		log.Printf("[processPendingBonus] (worker %d) enviando requisição para processar a bonificação de fidelidade", worker)
+		ctx, cancel := context.WithTimeout(context.Background(), queue.timeout)
+		_, err := callWithBreaker(ctx, breakers.Fidelity, func() (int, error) {
+			if bonus.Kind == DeliveryReversal {
+				return trySendReversalRequest(ctx, bonus)
+			}
+			return trySendFidelityRequest(ctx, bonus.Request)
+		})
+		cancel()
+		if err != nil && isFidelityUnreachable(err) {
+			// Não é culpa do bônus: a tentativa não conta e as entregas ficam pausadas até o Fidelity voltar
+			fidelityHealth.ReportFailure(err)
+			continue
+		}
+		if errors.Is(err, ErrCircuitOpen) {
+			// O bônus nem chegou ao Fidelity: a tentativa não conta e o worker espera o circuito reabrir
+			wait := max(breakers.Fidelity.RetryAfter(), circuitOpenPause)
+			log.Printf("[processPendingBonus] (worker %d) Circuito do Fidelity aberto, aguardando %v para entregar o bonus %s", worker, wait.Round(time.Millisecond), bonus.ID)
+			if !queue.pause(wait) {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			next, deadLettered, journalErr := queue.Attempt(bonus.ID, err)
+			if journalErr != nil {
+				log.Printf("[processPendingBonus] ERRO: %v", journalErr)
+			}
+			if deadLettered {
+				log.Printf("[processPendingBonus] (worker %d) Falha ao processar bonus %s para %s após %d tentativa(s). Movido para o dead-letter: %v", worker, bonus.ID, bonus.Request.User, bonus.Attempts+1, err)
+			} else {
+				log.Printf("[processPendingBonus] (worker %d) Falha ao processar bonus para %s (tentativa %d). Próxima tentativa em %v", worker, bonus.Request.User, bonus.Attempts+1, time.Until(next).Round(time.Millisecond))
+			}
+			continue
+		}
+
+		log.Printf("[processPendingBonus] (worker %d) Bonus para %s processado com sucesso.", worker, bonus.Request.User)
+		if err := queue.Ack(bonus.ID); err != nil {
+			log.Printf("[processPendingBonus] ERRO: %v", err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fsousabt/shared/journal"
+)
+
+// Abre a fila e o dead-letter em dir e os coloca nas variáveis globais usadas pelos handlers e pelo redrive
+func openTestBonusQueue(t *testing.T, dir string, c BonusQueueConfig) (*PendingBonusQueue, *DeadLetterStore) {
+	t.Helper()
+	c.Dir = dir
+	c.Journal = journal.Config{Sync: journal.SyncNever}
+	c.SnapshotEvery = 1000
+	c.Workers = 1
+
+	letters, err := NewDeadLetterStore(c)
+	if err != nil {
+		t.Fatalf("NewDeadLetterStore: %v", err)
+	}
+	q, err := NewPendingBonusQueue(c, letters)
+	if err != nil {
+		t.Fatalf("NewPendingBonusQueue: %v", err)
+	}
+
+	previousQueue, previousLetters := pendingBonusQueue, deadLetters
+	pendingBonusQueue, deadLetters = q, letters
+	t.Cleanup(func() {
+		pendingBonusQueue, deadLetters = previousQueue, previousLetters
+		q.Close()
+		letters.Close()
+	})
+	return q, letters
+}
+
+// Usuários dos bônus pendentes, na ordem de entrega: primeiro os em memória, depois os em disco
+func queuedUsers(t *testing.T, q *PendingBonusQueue) []string {
+	t.Helper()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var users []string
+	for _, id := range q.order {
+		users = append(users, q.pending[id].Request.User)
+	}
+	spilled, err := q.spill.Remaining()
+	if err != nil {
+		t.Fatalf("Remaining: %v", err)
+	}
+	for _, bonus := range spilled {
+		users = append(users, bonus.Request.User)
+	}
+	return users
+}
+
+func TestPendingBonusQueueOverflow(t *testing.T) {
+	tests := []struct {
+		policy      OverflowPolicy
+		wantErr     error
+		wantUsers   []string
+		wantSpilled int
+		wantDead    []string // usuários dos bônus movidos para o dead-letter
+	}{
+		{policy: OverflowReject, wantErr: ErrBonusQueueFull, wantUsers: []string{"ana", "bruno"}},
+		{policy: OverflowSpill, wantUsers: []string{"ana", "bruno", "carla"}, wantSpilled: 1},
+		{policy: OverflowDropOldest, wantUsers: []string{"bruno", "carla"}, wantDead: []string{"ana"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			dir := t.TempDir()
+			config := BonusQueueConfig{Capacity: 2, MaxAttempts: 3, Overflow: tt.policy}
+			q, letters := openTestBonusQueue(t, dir, config)
+
+			var err error
+			for i, user := range []string{"ana", "bruno", "carla"} {
+				err = q.Enqueue(FidelityRequest{User: user, Bonus: i + 1})
+				if i < 2 && err != nil {
+					t.Fatalf("Enqueue com a fila vazia: %v", err)
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Enqueue com a fila cheia err = %v, want %v", err, tt.wantErr)
+			}
+
+			if users := queuedUsers(t, q); !slices.Equal(users, tt.wantUsers) {
+				t.Fatalf("fila = %v, want %v", users, tt.wantUsers)
+			}
+			if status := q.Status(); status.Spilled != tt.wantSpilled || status.LastOverflowAt == nil {
+				t.Fatalf("Status = %+v, want %d em disco e overflow registrado", status, tt.wantSpilled)
+			}
+			var dead []string
+			for _, letter := range letters.List("") {
+				dead = append(dead, letter.Bonus.Request.User)
+			}
+			if !slices.Equal(dead, tt.wantDead) {
+				t.Fatalf("dead-letter = %v, want %v", dead, tt.wantDead)
+			}
+
+			// a fila volta igual depois de um reinício, com o excedente de novo em disco
+			q.Close()
+			letters.Close()
+			q, letters = openTestBonusQueue(t, dir, config)
+			if users := queuedUsers(t, q); !slices.Equal(users, tt.wantUsers) {
+				t.Fatalf("fila após reinício = %v, want %v", users, tt.wantUsers)
+			}
+			if q.Status().Spilled != tt.wantSpilled || letters.Len() != len(tt.wantDead) {
+				t.Fatalf("após reinício: %d em disco e %d no dead-letter, want %d e %d",
+					q.Status().Spilled, letters.Len(), tt.wantSpilled, len(tt.wantDead))
+			}
+		})
+	}
+}
+
+func TestPendingBonusQueueRefillsFromSpill(t *testing.T) {
+	q, _ := openTestBonusQueue(t, t.TempDir(), BonusQueueConfig{Capacity: 1, MaxAttempts: 3, Overflow: OverflowSpill})
+	for _, user := range []string{"ana", "bruno", "carla"} {
+		if err := q.Enqueue(FidelityRequest{User: user, Bonus: 10}); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+
+	var delivered []string
+	for q.Len() > 0 {
+		bonus, _, ok := q.ready(0)
+		if !ok {
+			t.Fatalf("nenhum bônus pronto com %d pendente(s)", q.Len())
+		}
+		delivered = append(delivered, bonus.Request.User)
+		if err := q.Ack(bonus.ID); err != nil {
+			t.Fatalf("Ack: %v", err)
+		}
+	}
+	if want := []string{"ana", "bruno", "carla"}; !slices.Equal(delivered, want) {
+		t.Fatalf("entregues = %v, want %v", delivered, want)
+	}
+}
+
+func TestPendingBonusQueueBackoffHoldsOnlyTheSameUser(t *testing.T) {
+	q, _ := openTestBonusQueue(t, t.TempDir(), BonusQueueConfig{
+		Capacity:    10,
+		MaxAttempts: 3,
+		Backoff:     RetryConfig{BaseDelay: time.Hour},
+	})
+	for _, user := range []string{"ana", "ana", "bruno"} {
+		if err := q.Enqueue(FidelityRequest{User: user, Bonus: 10}); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+
+	first, _, _ := q.ready(0)
+	if _, _, err := q.Attempt(first.ID, errors.New("Fidelity recusou")); err != nil {
+		t.Fatalf("Attempt: %v", err)
+	}
+
+	// o segundo bônus da ana espera o primeiro; o do bruno segue
+	next, _, ok := q.ready(0)
+	if !ok || next.Request.User != "bruno" {
+		t.Fatalf("próximo bônus = %+v (ok=%v), want o do bruno", next, ok)
+	}
+	q.Ack(next.ID)
+	if _, wait, ok := q.ready(0); ok || wait <= 0 || wait > time.Hour {
+		t.Fatalf("ready com a ana em backoff: ok=%v wait=%v", ok, wait)
+	}
+}
+
+func TestPendingBonusQueueDeadLetterAndRedrive(t *testing.T) {
+	dir := t.TempDir()
+	config := BonusQueueConfig{Capacity: 10, MaxAttempts: 2, Backoff: RetryConfig{BaseDelay: time.Hour}}
+	q, letters := openTestBonusQueue(t, dir, config)
+	if err := q.Enqueue(FidelityRequest{User: "ana", Bonus: 10, TransactionID: "venda-1"}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	bonus, _, _ := q.ready(0)
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		_, deadLettered, err := q.Attempt(bonus.ID, errors.New("Fidelity recusou"))
+		if err != nil {
+			t.Fatalf("Attempt: %v", err)
+		}
+		if deadLettered != (attempt == 2) {
+			t.Fatalf("tentativa %d: dead-letter = %v", attempt, deadLettered)
+		}
+	}
+	letter, ok := letters.Get(bonus.ID)
+	if !ok || q.Len() != 0 || letter.Bonus.Attempts != 2 || letter.Bonus.LastError != "Fidelity recusou" {
+		t.Fatalf("dead-letter = %+v (ok=%v), fila com %d", letter, ok, q.Len())
+	}
+
+	// o dead-letter sobrevive a um reinício
+	q.Close()
+	letters.Close()
+	q, letters = openTestBonusQueue(t, dir, config)
+	if _, ok := letters.Get(bonus.ID); !ok || q.Len() != 0 {
+		t.Fatalf("após reinício: no dead-letter = %v, fila com %d", ok, q.Len())
+	}
+
+	if err := redrive(bonus.ID); err != nil {
+		t.Fatalf("redrive: %v", err)
+	}
+	redriven, _, ok := q.ready(0)
+	if !ok || redriven.ID != bonus.ID || redriven.Attempts != 0 || redriven.Request.TransactionID != "venda-1" {
+		t.Fatalf("bônus devolvido = %+v (ok=%v), want tentativas zeradas", redriven, ok)
+	}
+	if letters.Len() != 0 {
+		t.Fatalf("bônus continua no dead-letter após o redrive")
+	}
+}
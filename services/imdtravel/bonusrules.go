@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+type RoundingMode string
+
+const (
+	RoundNearest  RoundingMode = "nearest" // metade para longe do zero
+	RoundFloor    RoundingMode = "floor"
+	RoundCeil     RoundingMode = "ceil"
+	RoundHalfEven RoundingMode = "half-even"
+)
+
+// Multiplicador para voos cujo código casa com Flight (padrão do path.Match, ex: "05A8*")
+type RouteRule struct {
+	Flight     string  `json:"flight"`
+	Multiplier float64 `json:"multiplier"`
+}
+
+// Multiplicador válido para compras feitas entre Start (inclusive) e End (exclusive).
+// Flight é opcional e restringe a promoção aos voos que casam com o padrão.
+type Promotion struct {
+	Name       string    `json:"name"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Flight     string    `json:"flight,omitempty"`
+	Multiplier float64   `json:"multiplier"`
+}
+
+// Regras de cálculo do bônus, lidas de um arquivo JSON:
+// pontos = valor do voo (USD) x PointsPerDollar x rota x promoção x categoria, arredondado com Rounding.
+// Vale a primeira rota que casar e a maior promoção ativa (promoções não se acumulam).
+type BonusRules struct {
+	PointsPerDollar float64            `json:"pointsPerDollar"`
+	Rounding        RoundingMode       `json:"rounding"`
+	Routes          []RouteRule        `json:"routes"`
+	Promotions      []Promotion        `json:"promotions"`
+	Tiers           map[string]float64 `json:"tiers"` // SILVER, GOLD, PLATINUM
+}
+
+// Regras usadas sem arquivo: 1 ponto por dólar, arredondado para o inteiro mais próximo
+func defaultBonusRules() *BonusRules {
+	return &BonusRules{PointsPerDollar: 1, Rounding: RoundNearest}
+}
+
+func LoadBonusRules(file string) (*BonusRules, error) {
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("AVISO: arquivo de regras de bônus %s não encontrado, usando 1 ponto por dólar", file)
+		return defaultBonusRules(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules := defaultBonusRules()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(rules); err != nil {
+		return nil, fmt.Errorf("arquivo de regras de bônus %s inválido: %w", file, err)
+	}
+	if err := rules.validate(); err != nil {
+		return nil, fmt.Errorf("arquivo de regras de bônus %s inválido: %w", file, err)
+	}
+
+	log.Printf("Regras de bônus carregadas de %s: %d rota(s), %d promoção(ões), categorias %v",
+		file, len(rules.Routes), len(rules.Promotions), rules.Tiers)
+	return rules, nil
+}
+
+func (r *BonusRules) validate() error {
+	if r.PointsPerDollar < 0 {
+		return fmt.Errorf("pointsPerDollar não pode ser negativo")
+	}
+	switch r.Rounding {
+	case RoundNearest, RoundFloor, RoundCeil, RoundHalfEven:
+	default:
+		return fmt.Errorf("rounding deve ser %s, %s, %s ou %s", RoundNearest, RoundFloor, RoundCeil, RoundHalfEven)
+	}
+
+	for _, route := range r.Routes {
+		if _, err := path.Match(route.Flight, ""); err != nil || route.Flight == "" {
+			return fmt.Errorf("padrão de voo inválido na rota %q", route.Flight)
+		}
+		if route.Multiplier < 0 {
+			return fmt.Errorf("multiplicador negativo na rota %q", route.Flight)
+		}
+	}
+
+	for _, promotion := range r.Promotions {
+		if _, err := path.Match(promotion.Flight, ""); err != nil {
+			return fmt.Errorf("padrão de voo inválido na promoção %q", promotion.Name)
+		}
+		if !promotion.End.After(promotion.Start) {
+			return fmt.Errorf("promoção %q termina antes de começar", promotion.Name)
+		}
+		if promotion.Multiplier < 0 {
+			return fmt.Errorf("multiplicador negativo na promoção %q", promotion.Name)
+		}
+	}
+
+	tiers := make(map[string]float64, len(r.Tiers))
+	for tier, multiplier := range r.Tiers {
+		tier = strings.ToUpper(tier)
+		switch tier {
+		case "SILVER", "GOLD", "PLATINUM":
+		default:
+			return fmt.Errorf("categoria desconhecida %q (esperado SILVER, GOLD ou PLATINUM)", tier)
+		}
+		if multiplier < 0 {
+			return fmt.Errorf("multiplicador negativo na categoria %s", tier)
+		}
+		tiers[tier] = multiplier
+	}
+	r.Tiers = tiers
+	return nil
+}
+
+// Detalhes de um cálculo de bônus, para o log
+type BonusCalculation struct {
+	Value           float64
+	PointsPerDollar float64
+	RouteMultiplier float64
+	Promotion       string
+	PromoMultiplier float64
+	Tier            string
+	TierMultiplier  float64
+	Points          int
+}
+
+func (c BonusCalculation) String() string {
+	promotion := "sem promoção"
+	if c.Promotion != "" {
+		promotion = "promoção " + c.Promotion
+	}
+	return fmt.Sprintf("%.2f USD x %.2f pontos/USD x %.2f (rota) x %.2f (%s) x %.2f (%s) = %d pontos",
+		c.Value, c.PointsPerDollar, c.RouteMultiplier, c.PromoMultiplier, promotion, c.TierMultiplier, c.Tier, c.Points)
+}
+
+// Compute calcula o bônus de uma compra do voo flight de valor value (USD), feita em at por um usuário da categoria tier
+func (r *BonusRules) Compute(flight string, value float64, tier string, at time.Time) BonusCalculation {
+	calc := BonusCalculation{
+		Value:           value,
+		PointsPerDollar: r.PointsPerDollar,
+		RouteMultiplier: 1,
+		PromoMultiplier: 1,
+		Tier:            tier,
+		TierMultiplier:  1,
+	}
+
+	for _, route := range r.Routes {
+		if matched, _ := path.Match(route.Flight, flight); matched {
+			calc.RouteMultiplier = route.Multiplier
+			break
+		}
+	}
+
+	for _, promotion := range r.Promotions {
+		if at.Before(promotion.Start) || !at.Before(promotion.End) {
+			continue
+		}
+		if promotion.Flight != "" {
+			if matched, _ := path.Match(promotion.Flight, flight); !matched {
+				continue
+			}
+		}
+		if calc.Promotion == "" || promotion.Multiplier > calc.PromoMultiplier {
+			calc.Promotion = promotion.Name
+			calc.PromoMultiplier = promotion.Multiplier
+		}
+	}
+
+	if multiplier, ok := r.Tiers[tier]; ok {
+		calc.TierMultiplier = multiplier
+	}
+
+	points := value * calc.PointsPerDollar * calc.RouteMultiplier * calc.PromoMultiplier * calc.TierMultiplier
+	// descarta o erro de ponto flutuante (ex: 100 x 1.1 = 110.00000000000001) antes de arredondar
+	points = math.Round(points*1e6) / 1e6
+	switch r.Rounding {
+	case RoundFloor:
+		points = math.Floor(points)
+	case RoundCeil:
+		points = math.Ceil(points)
+	case RoundHalfEven:
+		points = math.RoundToEven(points)
+	default:
+		points = math.Round(points)
+	}
+	calc.Points = int(points)
+	return calc
+}
+
+var bonusRules *BonusRules
+
+type TierResponse struct {
+	Tier string `json:"tier"`
+}
+
+// Busca a categoria do usuário no Fidelity. O bônus não pode atrasar nem derrubar a compra: se o Fidelity
+// estiver fora do ar a busca não é repetida e o bônus é calculado sem o multiplicador de categoria.
+func lookupTier(ctx context.Context, ft bool, user string) string {
+	const fallback = "STANDARD"
+
+	if ft && fidelityHealth.Paused() {
+		log.Printf("AVISO: Fidelity fora do ar, bônus do usuário %s calculado sem categoria", user)
+		return fallback
+	}
+
+	var tier string
+	var err error
+	if ft {
+		tier, err = callWithBreaker(ctx, breakers.Fidelity, func() (string, error) {
+			return fetchTier(ctx, ft, user)
+		})
+	} else {
+		tier, err = fetchTier(ctx, ft, user)
+	}
+	if err != nil {
+		log.Printf("AVISO: falha ao buscar categoria do usuário %s, bônus calculado sem categoria: %v", user, err)
+		return fallback
+	}
+	return tier
+}
+
+func fetchTier(ctx context.Context, ft bool, user string) (string, error) {
+	endpoint := fmt.Sprintf("%s/users/%s/tier", cfg.URL.Fidelity, url.PathEscape(user))
+	req, err := newRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("falha ao criar requisição para %s: %w", endpoint, err)
+	}
+
+	var resp *http.Response
+	if ft {
+		resp, err = ftHttpClient.Do(req)
+	} else {
+		resp, err = client.Do(req)
+	}
+	if err != nil {
+		return "", fmt.Errorf("falha ao fazer requisição para %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", &HTTPStatusError{Service: "Fidelity", StatusCode: resp.StatusCode, Message: string(bodyBytes)}
+	}
+
+	var tier TierResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tier); err != nil {
+		return "", fmt.Errorf("falha ao decodificar resposta do Fidelity: %w", err)
+	}
+	return tier.Tier, nil
+}
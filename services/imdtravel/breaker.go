@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type CircuitState int
+
+const (
+	StateClosed CircuitState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case StateClosed:
+		return "CLOSED"
+	case StateOpen:
+		return "OPEN"
+	case StateHalfOpen:
+		return "HALF_OPEN"
+	}
+	return "UNKNOWN"
+}
+
+var ErrCircuitOpen = errors.New("circuito aberto, chamada rejeitada sem contatar o serviço")
+
+// Circuit Breaker
+// CLOSED    -> chamadas passam normalmente; após FailureThreshold falhas consecutivas abre o circuito
+// OPEN      -> chamadas falham imediatamente com ErrCircuitOpen até passar o CoolDown
+// HALF_OPEN -> até HalfOpenMaxCalls chamadas de teste; se todas tiverem sucesso fecha, se uma falhar reabre
+type CircuitBreaker struct {
+	mu sync.Mutex
+
+	name             string
+	failureThreshold int
+	coolDown         time.Duration
+	halfOpenMaxCalls int
+
+	state             CircuitState
+	failures          int
+	halfOpenCalls     int
+	halfOpenSuccesses int
+	openedAt          time.Time
+	lastError         string
+}
+
+type BreakerStatus struct {
+	Name                string     `json:"name"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	FailureThreshold    int        `json:"failureThreshold"`
+	CoolDown            string     `json:"coolDown"`
+	OpenedAt            *time.Time `json:"openedAt,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+}
+
+func NewCircuitBreaker(name string, c BreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{
+		name:             name,
+		failureThreshold: max(c.FailureThreshold, 1),
+		coolDown:         c.CoolDown,
+		halfOpenMaxCalls: max(c.HalfOpenMaxCalls, 1),
+		state:            StateClosed,
+	}
+}
+
+func (cb *CircuitBreaker) setState(state CircuitState) {
+	if cb.state == state {
+		return
+	}
+	log.Printf("[CircuitBreaker] (%s) %s -> %s", cb.name, cb.state, state)
+	cb.state = state
+	cb.failures = 0
+	cb.halfOpenCalls = 0
+	cb.halfOpenSuccesses = 0
+	if state == StateOpen {
+		cb.openedAt = time.Now()
+	}
+}
+
+// Allow verifica se a chamada pode ser feita. Deve ser seguido de Record (com o resultado) ou Release.
+func (cb *CircuitBreaker) Allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == StateOpen {
+		if time.Since(cb.openedAt) < cb.coolDown {
+			return fmt.Errorf("%s: %w", cb.name, ErrCircuitOpen)
+		}
+		cb.setState(StateHalfOpen)
+	}
+
+	if cb.state == StateHalfOpen {
+		if cb.halfOpenCalls >= cb.halfOpenMaxCalls {
+			return fmt.Errorf("%s: %w", cb.name, ErrCircuitOpen)
+		}
+		cb.halfOpenCalls++
+	}
+
+	return nil
+}
+
+func (cb *CircuitBreaker) Record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if err != nil {
+		cb.lastError = err.Error()
+	}
+
+	switch cb.state {
+	case StateClosed:
+		if err == nil {
+			cb.failures = 0
+			return
+		}
+		cb.failures++
+		if cb.failures >= cb.failureThreshold {
+			cb.setState(StateOpen)
+		}
+	case StateHalfOpen:
+		if err != nil {
+			cb.setState(StateOpen)
+			return
+		}
+		cb.halfOpenSuccesses++
+		if cb.halfOpenSuccesses >= cb.halfOpenMaxCalls {
+			cb.setState(StateClosed)
+		}
+	case StateOpen:
+		// chamada iniciada antes da abertura do circuito, resultado ignorado
+	}
+}
+
+// Release libera a vaga de chamada de teste sem contabilizar sucesso ou falha
+func (cb *CircuitBreaker) Release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == StateHalfOpen && cb.halfOpenCalls > 0 {
+		cb.halfOpenCalls--
+	}
+}
+
+// RetryAfter retorna quanto falta para o circuito aberto voltar a aceitar chamadas (0 se não está aberto)
+func (cb *CircuitBreaker) RetryAfter() time.Duration {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state != StateOpen {
+		return 0
+	}
+	return max(cb.coolDown-time.Since(cb.openedAt), 0)
+}
+
+func (cb *CircuitBreaker) Status() BreakerStatus {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	status := BreakerStatus{
+		Name:                cb.name,
+		State:               cb.state.String(),
+		ConsecutiveFailures: cb.failures,
+		FailureThreshold:    cb.failureThreshold,
+		CoolDown:            cb.coolDown.String(),
+		LastError:           cb.lastError,
+	}
+	if cb.state != StateClosed {
+		openedAt := cb.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+// Executa fn protegida pelo circuit breaker.
+// Falhas causadas pelo fim do prazo (ou cancelamento) de ctx não são culpa do serviço e não contam.
+// Respostas 4xx (ex: saldo insuficiente) mostram que o serviço está respondendo e contam como sucesso.
+func callWithBreaker[T any](ctx context.Context, cb *CircuitBreaker, fn func() (T, error)) (T, error) {
+	var zero T
+	if err := cb.Allow(); err != nil {
+		log.Printf("[CircuitBreaker] %v", err)
+		return zero, err
+	}
+
+	result, err := fn()
+	if err != nil && ctx.Err() != nil {
+		cb.Release()
+		return result, err
+	}
+	if isClientError(err) {
+		cb.Record(nil)
+		return result, err
+	}
+	cb.Record(err)
+	return result, err
+}
+
+type Breakers struct {
+	Flight   *CircuitBreaker
+	Exchange *CircuitBreaker
+	Sell     *CircuitBreaker
+	Fidelity *CircuitBreaker
+}
+
+func newBreakers(c BreakerConfig) Breakers {
+	return Breakers{
+		Flight:   NewCircuitBreaker("airlineshub-flight", c),
+		Exchange: NewCircuitBreaker("exchange-convert", c),
+		Sell:     NewCircuitBreaker("airlineshub-sell", c),
+		Fidelity: NewCircuitBreaker("fidelity-bonus", c),
+	}
+}
+
+func (b Breakers) All() []*CircuitBreaker {
+	return []*CircuitBreaker{b.Flight, b.Exchange, b.Sell, b.Fidelity}
+}
+
+var breakers = newBreakers(cfg.Breaker)
+
+func circuitBreakersHandler(w http.ResponseWriter, r *http.Request) {
+	statuses := []BreakerStatus{}
+	for _, cb := range breakers.All() {
+		statuses = append(statuses, cb.Status())
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type flightCacheEntry struct {
+	key        string
+	value      *FlightData
+	storedAt   time.Time
+	refreshing bool
+}
+
+// Cache de voos seguro para uso concorrente, com limite de tamanho (LRU) e idade:
+// - idade < TTL: valor fresco, usado sem consultar o AirlinesHub
+// - TTL <= idade < TTL+StaleWhileRevalidate: valor ainda usado, mas atualizado em segundo plano
+// - idade < MaxStaleness: valor aceito apenas como fallback quando o AirlinesHub falha
+type FlightCache struct {
+	mu sync.Mutex
+
+	capacity             int
+	ttl                  time.Duration
+	staleWhileRevalidate time.Duration
+	maxStaleness         time.Duration
+
+	entries map[string]*list.Element
+	lru     *list.List // frente = usado mais recentemente
+}
+
+func NewFlightCache(c FlightCacheConfig) *FlightCache {
+	return &FlightCache{
+		capacity:             max(c.Size, 1),
+		ttl:                  c.TTL,
+		staleWhileRevalidate: c.StaleWhileRevalidate,
+		maxStaleness:         c.MaxStaleness,
+		entries:              make(map[string]*list.Element),
+		lru:                  list.New(),
+	}
+}
+
+func (c *FlightCache) Set(key string, value *FlightData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*flightCacheEntry)
+		entry.value = value
+		entry.storedAt = time.Now()
+		entry.refreshing = false
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&flightCacheEntry{key: key, value: value, storedAt: time.Now()})
+
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*flightCacheEntry).key)
+	}
+}
+
+// Retorna a entrada se ela for mais nova que maxAge. Entradas mais velhas que maxStaleness são removidas.
+func (c *FlightCache) get(key string, maxAge time.Duration) (*flightCacheEntry, time.Duration, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, 0, false
+	}
+
+	entry := el.Value.(*flightCacheEntry)
+	age := time.Since(entry.storedAt)
+	if age >= max(c.maxStaleness, c.ttl+c.staleWhileRevalidate) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil, 0, false
+	}
+	if age >= maxAge {
+		return nil, age, false
+	}
+
+	c.lru.MoveToFront(el)
+	return entry, age, true
+}
+
+// GetOrRevalidate retorna um valor "fresco o suficiente" sem bloquear no AirlinesHub.
+// Se o valor já passou do TTL, mas está dentro da janela de stale-while-revalidate, ele é
+// retornado e refresh é disparado em segundo plano (no máximo uma atualização por chave).
+func (c *FlightCache) GetOrRevalidate(key string, refresh func(ctx context.Context) error) (*FlightData, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, age, ok := c.get(key, c.ttl+c.staleWhileRevalidate)
+	if !ok {
+		return nil, false
+	}
+
+	if age >= c.ttl && !entry.refreshing {
+		entry.refreshing = true
+		go c.revalidate(key, refresh)
+	}
+
+	return entry.value, true
+}
+
+func (c *FlightCache) revalidate(key string, refresh func(ctx context.Context) error) {
+	log.Printf("[FlightCache] Atualizando em segundo plano o voo %s", key)
+
+	ctx, cancel := newBackgroundContext()
+	defer cancel()
+
+	if err := refresh(ctx); err != nil {
+		log.Printf("[FlightCache] Falha ao atualizar em segundo plano o voo %s: %v", key, err)
+		c.mu.Lock()
+		if el, ok := c.entries[key]; ok {
+			el.Value.(*flightCacheEntry).refreshing = false
+		}
+		c.mu.Unlock()
+	}
+}
+
+// GetStale retorna o valor para uso como fallback, desde que não seja mais velho que maxStaleness
+func (c *FlightCache) GetStale(key string) (*FlightData, time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, age, ok := c.get(key, c.maxStaleness)
+	if !ok {
+		return nil, age, false
+	}
+	return entry.value, age, true
+}
+
+var flightCache = NewFlightCache(cfg.FlightCache)
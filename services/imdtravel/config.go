@@ -3,6 +3,10 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
+
+	"github.com/fsousabt/shared/journal"
 )
 
 type URL struct {
@@ -10,32 +14,278 @@ type URL struct {
 	Exchange    string
 	Fidelity    string
 }
+
+type BreakerConfig struct {
+	FailureThreshold int
+	CoolDown         time.Duration
+	HalfOpenMaxCalls int
+}
+
+type RetryConfig struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	MaxElapsed  time.Duration
+	Jitter      JitterStrategy
+}
+
+type RetryConfigs struct {
+	Flight   RetryConfig
+	Exchange RetryConfig
+	Sell     RetryConfig
+	Fidelity RetryConfig
+}
+
+type FlightCacheConfig struct {
+	Size                 int
+	TTL                  time.Duration
+	StaleWhileRevalidate time.Duration
+	MaxStaleness         time.Duration
+}
+
+type RateEstimatorConfig struct {
+	Strategy         string
+	Size             int
+	MaxStaleness     time.Duration
+	EWMAAlpha        float64
+	OutlierThreshold float64
+}
+
+type TicketStoreConfig struct {
+	Backend       string
+	Dir           string
+	Journal       journal.Config
+	SnapshotEvery int
+}
+
+type IdempotencyConfig struct {
+	TTL           time.Duration
+	Dir           string
+	Journal       journal.Config
+	SnapshotEvery int
+}
+
+type SagaConfig struct {
+	Dir           string
+	Journal       journal.Config
+	SnapshotEvery int
+	RetryInterval time.Duration
+}
+
+// Conferência com o AirlinesHub das compras cuja venda ficou incerta (timeout na venda)
+type ReconcileConfig struct {
+	Delay          time.Duration // espera antes da primeira consulta, para a venda atrasada terminar
+	Interval       time.Duration
+	CompleteWindow time.Duration // idade máxima da compra para concluí-la quando a venda é encontrada
+	MaxAttempts    int           // consultas sem resposta do AirlinesHub antes da análise manual
+	Dir            string
+	Journal        journal.Config
+	SnapshotEvery  int
+}
+
+type HealthConfig struct {
+	Interval            time.Duration
+	Timeout             time.Duration
+	RampUp              time.Duration
+	RampUpStartInterval time.Duration
+}
+
+type BonusQueueConfig struct {
+	Capacity        int
+	MaxAttempts     int
+	Workers         int
+	DeliveryTimeout time.Duration // prazo de cada entrega ao Fidelity
+	Backoff         RetryConfig   // só BaseDelay, MaxDelay e Jitter são usados
+	Health          HealthConfig
+	Overflow        OverflowPolicy
+	Dir             string
+	Journal         journal.Config
+	SnapshotEvery   int
+}
+
 type Config struct {
 	URL
+	Breaker       BreakerConfig
+	Retry         RetryConfigs
+	RequestBudget time.Duration
+	PointValue    float64 // valor em reais de cada ponto resgatado
+	BonusRules    string  // arquivo com as regras de cálculo do bônus
+	FlightCache   FlightCacheConfig
+	RateEstimator RateEstimatorConfig
+	TicketStore   TicketStoreConfig
+	Idempotency   IdempotencyConfig
+	Saga          SagaConfig
+	Reconcile     ReconcileConfig
+	BonusQueue    BonusQueueConfig
 }
 
 const (
 	AIRLINES_HUB_URL = "AIRLINES_HUB_URL"
 	EXCHANGE_URL     = "EXCHANGE_URL"
 	FIDELITY_URL     = "FIDELITY_URL"
+
+	CB_FAILURE_THRESHOLD   = "CB_FAILURE_THRESHOLD"
+	CB_COOL_DOWN           = "CB_COOL_DOWN"
+	CB_HALF_OPEN_MAX_CALLS = "CB_HALF_OPEN_MAX_CALLS"
+
+	REQUEST_BUDGET = "REQUEST_BUDGET"
+
+	POINT_VALUE      = "POINT_VALUE"
+	BONUS_RULES_FILE = "BONUS_RULES_FILE"
+
+	FLIGHT_CACHE_SIZE                   = "FLIGHT_CACHE_SIZE"
+	FLIGHT_CACHE_TTL                    = "FLIGHT_CACHE_TTL"
+	FLIGHT_CACHE_STALE_WHILE_REVALIDATE = "FLIGHT_CACHE_STALE_WHILE_REVALIDATE"
+	FLIGHT_CACHE_MAX_STALENESS          = "FLIGHT_CACHE_MAX_STALENESS"
+
+	RATE_ESTIMATOR_STRATEGY          = "RATE_ESTIMATOR_STRATEGY"
+	RATE_ESTIMATOR_SIZE              = "RATE_ESTIMATOR_SIZE"
+	RATE_ESTIMATOR_MAX_STALENESS     = "RATE_ESTIMATOR_MAX_STALENESS"
+	RATE_ESTIMATOR_EWMA_ALPHA        = "RATE_ESTIMATOR_EWMA_ALPHA"
+	RATE_ESTIMATOR_OUTLIER_THRESHOLD = "RATE_ESTIMATOR_OUTLIER_THRESHOLD"
+
+	DATA_DIR = "DATA_DIR"
+
+	TICKET_STORE                = "TICKET_STORE"
+	TICKET_STORE_FSYNC          = "TICKET_STORE_FSYNC"
+	TICKET_STORE_FSYNC_INTERVAL = "TICKET_STORE_FSYNC_INTERVAL"
+	TICKET_STORE_SNAPSHOT_EVERY = "TICKET_STORE_SNAPSHOT_EVERY"
+
+	IDEMPOTENCY_TTL            = "IDEMPOTENCY_TTL"
+	IDEMPOTENCY_FSYNC          = "IDEMPOTENCY_FSYNC"
+	IDEMPOTENCY_FSYNC_INTERVAL = "IDEMPOTENCY_FSYNC_INTERVAL"
+
+	SAGA_FSYNC          = "SAGA_FSYNC"
+	SAGA_FSYNC_INTERVAL = "SAGA_FSYNC_INTERVAL"
+	SAGA_RETRY_INTERVAL = "SAGA_RETRY_INTERVAL"
+
+	RECONCILE_DELAY           = "RECONCILE_DELAY"
+	RECONCILE_INTERVAL        = "RECONCILE_INTERVAL"
+	RECONCILE_COMPLETE_WINDOW = "RECONCILE_COMPLETE_WINDOW"
+	RECONCILE_MAX_ATTEMPTS    = "RECONCILE_MAX_ATTEMPTS"
+	RECONCILE_FSYNC           = "RECONCILE_FSYNC"
+	RECONCILE_FSYNC_INTERVAL  = "RECONCILE_FSYNC_INTERVAL"
+
+	BONUS_QUEUE_CAPACITY     = "BONUS_QUEUE_CAPACITY"
+	BONUS_QUEUE_OVERFLOW     = "BONUS_QUEUE_OVERFLOW"
+	BONUS_MAX_ATTEMPTS       = "BONUS_MAX_ATTEMPTS"
+	BONUS_WORKERS            = "BONUS_WORKERS"
+	BONUS_DELIVERY_TIMEOUT   = "BONUS_DELIVERY_TIMEOUT"
+	BONUS_BACKOFF_BASE_DELAY = "BONUS_BACKOFF_BASE_DELAY"
+	BONUS_BACKOFF_MAX_DELAY  = "BONUS_BACKOFF_MAX_DELAY"
+	BONUS_BACKOFF_JITTER     = "BONUS_BACKOFF_JITTER"
+
+	FIDELITY_HEALTH_INTERVAL   = "FIDELITY_HEALTH_INTERVAL"
+	FIDELITY_HEALTH_TIMEOUT    = "FIDELITY_HEALTH_TIMEOUT"
+	BONUS_RAMP_UP              = "BONUS_RAMP_UP"
+	BONUS_RAMP_UP_INTERVAL     = "BONUS_RAMP_UP_INTERVAL"
+	BONUS_QUEUE_FSYNC          = "BONUS_QUEUE_FSYNC"
+	BONUS_QUEUE_FSYNC_INTERVAL = "BONUS_QUEUE_FSYNC_INTERVAL"
 )
 
+func getEnvInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Valor inválido para %s (%q), usando padrão %d", name, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// Lê a política de retry de um serviço a partir de RETRY_<prefix>_*
+func getEnvRetryConfig(prefix string, fallback RetryConfig) RetryConfig {
+	return RetryConfig{
+		MaxAttempts: getEnvInt("RETRY_"+prefix+"_MAX_ATTEMPTS", fallback.MaxAttempts),
+		BaseDelay:   getEnvDuration("RETRY_"+prefix+"_BASE_DELAY", fallback.BaseDelay),
+		MaxDelay:    getEnvDuration("RETRY_"+prefix+"_MAX_DELAY", fallback.MaxDelay),
+		MaxElapsed:  getEnvDuration("RETRY_"+prefix+"_MAX_ELAPSED", fallback.MaxElapsed),
+		Jitter:      getEnvJitter("RETRY_"+prefix+"_JITTER", fallback.Jitter),
+	}
+}
+
+func getEnvJitter(name string, fallback JitterStrategy) JitterStrategy {
+	jitter := JitterStrategy(os.Getenv(name))
+	switch jitter {
+	case JitterNone, JitterFull, JitterEqual, JitterDecorrelated:
+		return jitter
+	case "":
+		return fallback
+	}
+	log.Printf("Valor inválido para %s (%q), usando padrão %s", name, jitter, fallback)
+	return fallback
+}
+
+func getEnvFloat(name string, fallback float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Valor inválido para %s (%q), usando padrão %v", name, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvString(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvSyncPolicy(name string, fallback journal.SyncPolicy) journal.SyncPolicy {
+	policy := journal.SyncPolicy(os.Getenv(name))
+	switch policy {
+	case journal.SyncAlways, journal.SyncInterval, journal.SyncNever:
+		return policy
+	case "":
+		return fallback
+	}
+	log.Printf("Valor inválido para %s (%q), usando padrão %s", name, policy, fallback)
+	return fallback
+}
+
+func getEnvOverflowPolicy(name string, fallback OverflowPolicy) OverflowPolicy {
+	policy := OverflowPolicy(os.Getenv(name))
+	switch policy {
+	case OverflowReject, OverflowSpill, OverflowDropOldest:
+		return policy
+	case "":
+		return fallback
+	}
+	log.Printf("Valor inválido para %s (%q), usando padrão %s", name, policy, fallback)
+	return fallback
+}
+
+func getEnvDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Valor inválido para %s (%q), usando padrão %v", name, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
 func MakeConfig() Config {
 	airlinesHubURL := os.Getenv(AIRLINES_HUB_URL)
 	exchangeURL := os.Getenv(EXCHANGE_URL)
 	fidelityURL := os.Getenv(FIDELITY_URL)
 
-	if airlinesHubURL == "" {
-		log.Fatalf("Faltando variável de ambiente %s", AIRLINES_HUB_URL)
-	}
-
 	if exchangeURL == "" {
-		log.Println("Faltando variável de ambiente %s", EXCHANGE_URL)
+		log.Printf("Faltando variável de ambiente %s", EXCHANGE_URL)
 	}
 
-	if fidelityURL == "" {
-		log.Fatalf("Faltando variável de ambiente %s", FIDELITY_URL)
-	}
+	dataDir := getEnvString(DATA_DIR, "data")
 
 	var cfg = Config{
 		URL: URL{
@@ -43,6 +293,104 @@ func MakeConfig() Config {
 			Exchange:    exchangeURL,
 			Fidelity:    fidelityURL,
 		},
+		Breaker: BreakerConfig{
+			FailureThreshold: getEnvInt(CB_FAILURE_THRESHOLD, 5),
+			CoolDown:         getEnvDuration(CB_COOL_DOWN, 10*time.Second),
+			HalfOpenMaxCalls: getEnvInt(CB_HALF_OPEN_MAX_CALLS, 1),
+		},
+		RequestBudget: getEnvDuration(REQUEST_BUDGET, 10*time.Second),
+		PointValue:    getEnvFloat(POINT_VALUE, 0.05),
+		BonusRules:    getEnvString(BONUS_RULES_FILE, "bonus-rules.json"),
+		FlightCache: FlightCacheConfig{
+			Size:                 getEnvInt(FLIGHT_CACHE_SIZE, 1000),
+			TTL:                  getEnvDuration(FLIGHT_CACHE_TTL, 30*time.Second),
+			StaleWhileRevalidate: getEnvDuration(FLIGHT_CACHE_STALE_WHILE_REVALIDATE, 2*time.Minute),
+			MaxStaleness:         getEnvDuration(FLIGHT_CACHE_MAX_STALENESS, 10*time.Minute),
+		},
+		RateEstimator: RateEstimatorConfig{
+			Strategy:         getEnvString(RATE_ESTIMATOR_STRATEGY, "ewma"),
+			Size:             getEnvInt(RATE_ESTIMATOR_SIZE, 20),
+			MaxStaleness:     getEnvDuration(RATE_ESTIMATOR_MAX_STALENESS, 5*time.Minute),
+			EWMAAlpha:        getEnvFloat(RATE_ESTIMATOR_EWMA_ALPHA, 0.3),
+			OutlierThreshold: getEnvFloat(RATE_ESTIMATOR_OUTLIER_THRESHOLD, 3),
+		},
+		TicketStore: TicketStoreConfig{
+			Backend: getEnvString(TICKET_STORE, "file"),
+			Dir:     dataDir,
+			Journal: journal.Config{
+				Sync:         getEnvSyncPolicy(TICKET_STORE_FSYNC, journal.SyncAlways),
+				SyncInterval: getEnvDuration(TICKET_STORE_FSYNC_INTERVAL, time.Second),
+			},
+			SnapshotEvery: getEnvInt(TICKET_STORE_SNAPSHOT_EVERY, 1000),
+		},
+		Idempotency: IdempotencyConfig{
+			TTL: getEnvDuration(IDEMPOTENCY_TTL, 24*time.Hour),
+			Dir: dataDir,
+			Journal: journal.Config{
+				Sync:         getEnvSyncPolicy(IDEMPOTENCY_FSYNC, journal.SyncAlways),
+				SyncInterval: getEnvDuration(IDEMPOTENCY_FSYNC_INTERVAL, time.Second),
+			},
+			SnapshotEvery: 1000,
+		},
+		Saga: SagaConfig{
+			Dir: dataDir,
+			Journal: journal.Config{
+				Sync:         getEnvSyncPolicy(SAGA_FSYNC, journal.SyncAlways),
+				SyncInterval: getEnvDuration(SAGA_FSYNC_INTERVAL, time.Second),
+			},
+			SnapshotEvery: 1000,
+			RetryInterval: getEnvDuration(SAGA_RETRY_INTERVAL, 10*time.Second),
+		},
+		Reconcile: ReconcileConfig{
+			Delay:          getEnvDuration(RECONCILE_DELAY, 10*time.Second),
+			Interval:       getEnvDuration(RECONCILE_INTERVAL, 5*time.Second),
+			CompleteWindow: getEnvDuration(RECONCILE_COMPLETE_WINDOW, 2*time.Minute),
+			MaxAttempts:    getEnvInt(RECONCILE_MAX_ATTEMPTS, 60),
+			Dir:            dataDir,
+			Journal: journal.Config{
+				Sync:         getEnvSyncPolicy(RECONCILE_FSYNC, journal.SyncAlways),
+				SyncInterval: getEnvDuration(RECONCILE_FSYNC_INTERVAL, time.Second),
+			},
+			SnapshotEvery: 1000,
+		},
+		BonusQueue: BonusQueueConfig{
+			Capacity:        getEnvInt(BONUS_QUEUE_CAPACITY, 100),
+			MaxAttempts:     getEnvInt(BONUS_MAX_ATTEMPTS, 10),
+			Workers:         getEnvInt(BONUS_WORKERS, 4),
+			DeliveryTimeout: getEnvDuration(BONUS_DELIVERY_TIMEOUT, 5*time.Second),
+			Overflow:        getEnvOverflowPolicy(BONUS_QUEUE_OVERFLOW, OverflowSpill),
+			Dir:             dataDir,
+			Backoff: RetryConfig{
+				BaseDelay: getEnvDuration(BONUS_BACKOFF_BASE_DELAY, time.Second),
+				MaxDelay:  getEnvDuration(BONUS_BACKOFF_MAX_DELAY, 5*time.Minute),
+				Jitter:    getEnvJitter(BONUS_BACKOFF_JITTER, JitterFull),
+			},
+			Health: HealthConfig{
+				Interval:            getEnvDuration(FIDELITY_HEALTH_INTERVAL, 2*time.Second),
+				Timeout:             getEnvDuration(FIDELITY_HEALTH_TIMEOUT, time.Second),
+				RampUp:              getEnvDuration(BONUS_RAMP_UP, 30*time.Second),
+				RampUpStartInterval: getEnvDuration(BONUS_RAMP_UP_INTERVAL, time.Second),
+			},
+			Journal: journal.Config{
+				Sync:         getEnvSyncPolicy(BONUS_QUEUE_FSYNC, journal.SyncAlways),
+				SyncInterval: getEnvDuration(BONUS_QUEUE_FSYNC_INTERVAL, time.Second),
+			},
+			SnapshotEvery: 1000,
+		},
+		Retry: RetryConfigs{
+			Flight: getEnvRetryConfig("FLIGHT", RetryConfig{
+				MaxAttempts: 3, BaseDelay: 200 * time.Millisecond, MaxDelay: 2 * time.Second, MaxElapsed: 6 * time.Second, Jitter: JitterFull,
+			}),
+			Exchange: getEnvRetryConfig("EXCHANGE", RetryConfig{
+				MaxAttempts: 2, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, MaxElapsed: 2 * time.Second, Jitter: JitterEqual,
+			}),
+			Sell: getEnvRetryConfig("SELL", RetryConfig{
+				MaxAttempts: 2, BaseDelay: 200 * time.Millisecond, MaxDelay: time.Second, MaxElapsed: 3 * time.Second, Jitter: JitterDecorrelated,
+			}),
+			Fidelity: getEnvRetryConfig("FIDELITY", RetryConfig{
+				MaxAttempts: 3, BaseDelay: 200 * time.Millisecond, MaxDelay: 2 * time.Second, MaxElapsed: 3 * time.Second, Jitter: JitterFull,
+			}),
+		},
 	}
 
 	log.Printf("config: %+v", cfg)
@@ -50,6 +398,18 @@ func MakeConfig() Config {
 	return cfg
 }
 
+// checkRequiredConfig encerra o serviço se faltar uma URL obrigatória. Fica fora de MakeConfig para que
+// cfg possa ser montado sem elas (nos testes, por exemplo).
+func checkRequiredConfig(c Config) {
+	if c.URL.AirlinesHub == "" {
+		log.Fatalf("Faltando variável de ambiente %s", AIRLINES_HUB_URL)
+	}
+
+	if c.URL.Fidelity == "" {
+		log.Fatalf("Faltando variável de ambiente %s", FIDELITY_URL)
+	}
+}
+
 var cfg = MakeConfig()
 
 func GetConfig() Config {
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fsousabt/shared/journal"
+	"github.com/google/uuid"
+)
+
+// Bônus que saiu da fila sem ser entregue ao Fidelity. Reason diz por que ele saiu da fila;
+// Bonus.LastError e Bonus.Attempts guardam a última falha de entrega.
+type DeadLetter struct {
+	Bonus  PendingBonus `json:"bonus"`
+	Reason string       `json:"reason"`
+	DeadAt time.Time    `json:"deadAt"`
+}
+
+type deadLetterRecord struct {
+	Op     string      `json:"op"`
+	ID     uuid.UUID   `json:"id,omitzero"`
+	Letter *DeadLetter `json:"letter,omitempty"`
+}
+
+const (
+	deadLetterOpAdd    = "add"
+	deadLetterOpRemove = "remove"
+)
+
+// Bônus que saíram da fila sem serem entregues ao Fidelity, guardados para inspeção e re-drive
+type DeadLetterStore struct {
+	mu sync.Mutex
+
+	letters       map[uuid.UUID]DeadLetter
+	journal       *journal.Journal
+	snapshotEvery int
+}
+
+func NewDeadLetterStore(c BonusQueueConfig) (*DeadLetterStore, error) {
+	journal, err := journal.Open(c.Dir, "bonus-dead-letter", c.Journal)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &DeadLetterStore{
+		letters:       make(map[uuid.UUID]DeadLetter),
+		journal:       journal,
+		snapshotEvery: max(c.SnapshotEvery, 1),
+	}
+
+	err = journal.Load(func(data []byte) error {
+		var record deadLetterRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		switch record.Op {
+		case deadLetterOpAdd:
+			if record.Letter != nil {
+				s.letters[record.Letter.Bonus.ID] = *record.Letter
+			}
+		case deadLetterOpRemove:
+			delete(s.letters, record.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("falha ao recuperar dead-letter de bônus: %w", err)
+	}
+
+	if len(s.letters) > 0 {
+		log.Printf("[DeadLetter] %d bônus no dead-letter", len(s.letters))
+	}
+	return s, nil
+}
+
+func (s *DeadLetterStore) Add(bonus PendingBonus, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	letter := DeadLetter{Bonus: bonus, Reason: reason, DeadAt: time.Now()}
+	s.letters[bonus.ID] = letter
+	return s.append(deadLetterRecord{Op: deadLetterOpAdd, ID: bonus.ID, Letter: &letter})
+}
+
+func (s *DeadLetterStore) Get(id uuid.UUID) (DeadLetter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	letter, ok := s.letters[id]
+	return letter, ok
+}
+
+// List retorna os bônus do dead-letter (de um usuário, se user não for vazio), dos mais recentes para os mais antigos
+func (s *DeadLetterStore) List(user string) []DeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	letters := make([]DeadLetter, 0, len(s.letters))
+	for _, letter := range s.letters {
+		if user == "" || letter.Bonus.Request.User == user {
+			letters = append(letters, letter)
+		}
+	}
+	slices.SortFunc(letters, func(a, b DeadLetter) int {
+		return b.DeadAt.Compare(a.DeadAt)
+	})
+	return letters
+}
+
+func (s *DeadLetterStore) Remove(id uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.letters[id]; !ok {
+		return false, nil
+	}
+	delete(s.letters, id)
+	return true, s.append(deadLetterRecord{Op: deadLetterOpRemove, ID: id})
+}
+
+func (s *DeadLetterStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.letters)
+}
+
+// Deve ser chamado com s.mu travado
+func (s *DeadLetterStore) append(record deadLetterRecord) error {
+	err := s.journal.Append(record)
+	if err != nil {
+		err = fmt.Errorf("falha ao gravar %s do bônus %s no dead-letter: %w", record.Op, record.ID, err)
+	}
+	if s.journal.Records() >= s.snapshotEvery {
+		s.snapshot()
+	}
+	return err
+}
+
+// Deve ser chamado com s.mu travado
+func (s *DeadLetterStore) snapshot() {
+	records := make([]any, 0, len(s.letters))
+	for _, letter := range s.letters {
+		records = append(records, deadLetterRecord{Op: deadLetterOpAdd, ID: letter.Bonus.ID, Letter: &letter})
+	}
+	if err := s.journal.Snapshot(records); err != nil {
+		log.Printf("[DeadLetter] ERRO: falha ao gravar snapshot: %v", err)
+	}
+}
+
+func (s *DeadLetterStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshot()
+	return s.journal.Close()
+}
+
+var deadLetters *DeadLetterStore
+
+type DeadLetterListResponse struct {
+	DeadLetters []DeadLetter `json:"deadLetters"`
+	Total       int          `json:"total"`
+	Limit       int          `json:"limit"`
+	Offset      int          `json:"offset"`
+}
+
+type DeadLetterRedriveResponse struct {
+	Redriven []uuid.UUID `json:"redriven"`
+	Failed   []uuid.UUID `json:"failed,omitempty"`
+}
+
+type DeadLetterPurgeResponse struct {
+	Purged []uuid.UUID `json:"purged"`
+}
+
+// GET /admin/deadLetters?user=joao&limit=20&offset=0
+func listDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, err := parseQueryInt(query.Get("limit"), defaultPageLimit)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		writeError(w, newAPIError(http.StatusBadRequest, fmt.Errorf("limit deve ser um número entre 1 e %d", maxPageLimit)))
+		return
+	}
+	offset, err := parseQueryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		writeError(w, newAPIError(http.StatusBadRequest, fmt.Errorf("offset deve ser um número maior ou igual a 0")))
+		return
+	}
+
+	letters := deadLetters.List(query.Get("user"))
+	writeJSON(w, http.StatusOK, DeadLetterListResponse{
+		DeadLetters: paginate(letters, limit, offset),
+		Total:       len(letters),
+		Limit:       limit,
+		Offset:      offset,
+	})
+}
+
+func parseDeadLetterID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, fmt.Errorf("id inválido: %w", err)))
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// GET /admin/deadLetters/{id}
+func getDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseDeadLetterID(w, r)
+	if !ok {
+		return
+	}
+
+	letter, ok := deadLetters.Get(id)
+	if !ok {
+		writeError(w, newAPIError(http.StatusNotFound, fmt.Errorf("bônus %s não está no dead-letter", id)))
+		return
+	}
+	writeJSON(w, http.StatusOK, letter)
+}
+
+// Devolve o bônus à fila e só então o remove do dead-letter
+func redrive(id uuid.UUID) error {
+	letter, ok := deadLetters.Get(id)
+	if !ok {
+		return nil
+	}
+	if err := pendingBonusQueue.Redrive(letter); err != nil {
+		return err
+	}
+	if _, err := deadLetters.Remove(id); err != nil {
+		log.Printf("[DeadLetter] ERRO: %v", err)
+	}
+	log.Printf("[DeadLetter] Bônus %s (usuário %s) devolvido à fila", id, letter.Bonus.Request.User)
+	return nil
+}
+
+// POST /admin/deadLetters/{id}/redrive
+func redriveDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseDeadLetterID(w, r)
+	if !ok {
+		return
+	}
+
+	if _, ok := deadLetters.Get(id); !ok {
+		writeError(w, newAPIError(http.StatusNotFound, fmt.Errorf("bônus %s não está no dead-letter", id)))
+		return
+	}
+	if err := redrive(id); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrBonusQueueFull) {
+			status = http.StatusServiceUnavailable
+		}
+		writeError(w, newAPIError(status, fmt.Errorf("falha ao devolver bônus %s à fila: %w", id, err)))
+		return
+	}
+	writeJSON(w, http.StatusOK, DeadLetterRedriveResponse{Redriven: []uuid.UUID{id}})
+}
+
+// POST /admin/deadLetters/redrive?user=joao
+// Devolve à fila todos os bônus do dead-letter (ou só os do usuário informado)
+func redriveAllDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	response := DeadLetterRedriveResponse{Redriven: []uuid.UUID{}}
+	for _, letter := range deadLetters.List(r.URL.Query().Get("user")) {
+		if err := redrive(letter.Bonus.ID); err != nil {
+			log.Printf("[DeadLetter] ERRO: falha ao devolver bônus %s à fila: %v", letter.Bonus.ID, err)
+			response.Failed = append(response.Failed, letter.Bonus.ID)
+			continue
+		}
+		response.Redriven = append(response.Redriven, letter.Bonus.ID)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// DELETE /admin/deadLetters/{id}
+func purgeDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseDeadLetterID(w, r)
+	if !ok {
+		return
+	}
+
+	removed, err := deadLetters.Remove(id)
+	if !removed {
+		writeError(w, newAPIError(http.StatusNotFound, fmt.Errorf("bônus %s não está no dead-letter", id)))
+		return
+	}
+	if err != nil {
+		log.Printf("[DeadLetter] ERRO: %v", err)
+	}
+	log.Printf("[DeadLetter] Bônus %s descartado", id)
+	writeJSON(w, http.StatusOK, DeadLetterPurgeResponse{Purged: []uuid.UUID{id}})
+}
+
+// DELETE /admin/deadLetters?user=joao
+// Descarta todos os bônus do dead-letter (ou só os do usuário informado)
+func purgeAllDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	response := DeadLetterPurgeResponse{Purged: []uuid.UUID{}}
+	for _, letter := range deadLetters.List(r.URL.Query().Get("user")) {
+		removed, err := deadLetters.Remove(letter.Bonus.ID)
+		if err != nil {
+			log.Printf("[DeadLetter] ERRO: %v", err)
+		}
+		if removed {
+			response.Purged = append(response.Purged, letter.Bonus.ID)
+		}
+	}
+	log.Printf("[DeadLetter] %d bônus descartado(s)", len(response.Purged))
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/fsousabt/shared/deadline"
+)
+
+// Monta uma requisição HTTP ligada ao contexto, propagando o prazo restante no header deadline.Header
+func newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	deadline.Set(req)
+	return req, nil
+}
+
+// Contexto da requisição /buyTicket: com tolerância a falhas todas as etapas compartilham o mesmo
+// orçamento de tempo (cfg.RequestBudget); sem tolerância só o cancelamento pelo cliente é propagado.
+func newBuyTicketContext(r *http.Request, ft bool) (context.Context, context.CancelFunc) {
+	if ft && cfg.RequestBudget > 0 {
+		return context.WithTimeout(r.Context(), cfg.RequestBudget)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// Contexto do trabalho em segundo plano (revalidação do cache, compensação, recuperação e reconciliação):
+// usa o mesmo orçamento de tempo das compras e, como elas, fica sem prazo com REQUEST_BUDGET=0.
+func newBackgroundContext() (context.Context, context.CancelFunc) {
+	if cfg.RequestBudget > 0 {
+		return context.WithTimeout(context.Background(), cfg.RequestBudget)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// Verifica se o prazo da requisição acabou ou se o cliente desistiu antes de iniciar a etapa step.
+// Retorna true (e responde ao cliente, quando ainda faz sentido) se o processamento deve ser abandonado.
+func abortIfDone(ctx context.Context, w http.ResponseWriter, step string) bool {
+	err := ctx.Err()
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) {
+		log.Printf("[Deadline] Cliente cancelou a requisição, abandonando etapa '%s'", step)
+		return true
+	}
+
+	log.Printf("[Deadline] Prazo da requisição esgotado antes da etapa '%s'", step)
+	apiErr := newAPIError(http.StatusGatewayTimeout, errors.New("prazo da requisição esgotado antes da etapa: "+step))
+	writeError(w, apiErr)
+	return true
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrNoRateData = errors.New("nenhuma cotação do dólar disponível")
+var ErrRateTooStale = errors.New("cotações do dólar disponíveis são antigas demais para precificar")
+
+type RateQuote struct {
+	Value float64
+	At    time.Time
+}
+
+type RateEstimate struct {
+	Value    float64
+	Strategy string
+	Age      time.Duration // idade da cotação mais recente usada na estimativa
+	Samples  int
+}
+
+// Estratégia de estimativa da cotação a partir das cotações recentes (ordenadas da mais antiga para a mais nova)
+type RateStrategy interface {
+	Name() string
+	Estimate(quotes []RateQuote) float64
+}
+
+// Média móvel exponencial: cotações mais novas pesam mais
+type EWMAStrategy struct {
+	Alpha float64
+}
+
+func (s EWMAStrategy) Name() string { return "ewma" }
+
+func (s EWMAStrategy) Estimate(quotes []RateQuote) float64 {
+	value := quotes[0].Value
+	for _, q := range quotes[1:] {
+		value = s.Alpha*q.Value + (1-s.Alpha)*value
+	}
+	return value
+}
+
+type MedianStrategy struct{}
+
+func (MedianStrategy) Name() string { return "median" }
+
+func (MedianStrategy) Estimate(quotes []RateQuote) float64 {
+	values := make([]float64, len(quotes))
+	for i, q := range quotes {
+		values[i] = q.Value
+	}
+	return median(values)
+}
+
+// Última cotação conhecida (a janela de tempo é dada pelo limite de idade do estimador)
+type LastKnownGoodStrategy struct{}
+
+func (LastKnownGoodStrategy) Name() string { return "last-known-good" }
+
+func (LastKnownGoodStrategy) Estimate(quotes []RateQuote) float64 {
+	return quotes[len(quotes)-1].Value
+}
+
+func NewRateStrategy(name string, alpha float64) (RateStrategy, error) {
+	switch name {
+	case "ewma":
+		if !(alpha > 0 && alpha <= 1) {
+			return nil, fmt.Errorf("alpha da estratégia ewma deve estar em (0, 1]: %v", alpha)
+		}
+		return EWMAStrategy{Alpha: alpha}, nil
+	case "median":
+		return MedianStrategy{}, nil
+	case "last-known-good":
+		return LastKnownGoodStrategy{}, nil
+	}
+	return nil, fmt.Errorf("estratégia de estimativa de cotação desconhecida: %s", name)
+}
+
+func median(values []float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// Descarta cotações que se afastam da mediana mais que threshold desvios absolutos medianos (MAD)
+func rejectOutliers(quotes []RateQuote, threshold float64) []RateQuote {
+	if len(quotes) < 3 {
+		return quotes
+	}
+
+	values := make([]float64, len(quotes))
+	for i, q := range quotes {
+		values[i] = q.Value
+	}
+	med := median(values)
+
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - med)
+	}
+	mad := median(deviations)
+	if mad == 0 {
+		return quotes
+	}
+
+	kept := make([]RateQuote, 0, len(quotes))
+	for _, q := range quotes {
+		if math.Abs(q.Value-med)/mad <= threshold {
+			kept = append(kept, q)
+		}
+	}
+	return kept
+}
+
+// Estimador da cotação do dólar usado como fallback quando o Exchange falha.
+// Guarda as últimas cotações com horário e recusa precificar com dados mais velhos que maxStaleness.
+type RateEstimator struct {
+	mu sync.Mutex
+
+	quotes           []RateQuote
+	capacity         int
+	maxStaleness     time.Duration
+	outlierThreshold float64
+	strategy         RateStrategy
+}
+
+// Um limite de outliers menor ou igual a 0 desliga o filtro. Limites abaixo de 1 são recusados: com eles
+// metade das cotações, ou todas, ficaria de fora.
+func NewRateEstimator(c RateEstimatorConfig) (*RateEstimator, error) {
+	strategy, err := NewRateStrategy(c.Strategy, c.EWMAAlpha)
+	if err != nil {
+		return nil, err
+	}
+	if c.OutlierThreshold > 0 && c.OutlierThreshold < 1 {
+		return nil, fmt.Errorf("limite de outliers deve ser 0 (desligado) ou pelo menos 1: %v", c.OutlierThreshold)
+	}
+
+	return &RateEstimator{
+		capacity:         max(c.Size, 1),
+		maxStaleness:     c.MaxStaleness,
+		outlierThreshold: c.OutlierThreshold,
+		strategy:         strategy,
+	}, nil
+}
+
+func (e *RateEstimator) Record(value float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.quotes = append(e.quotes, RateQuote{Value: value, At: time.Now()})
+	if len(e.quotes) > e.capacity {
+		e.quotes = slices.Clone(e.quotes[len(e.quotes)-e.capacity:])
+	}
+}
+
+func (e *RateEstimator) Estimate() (RateEstimate, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.quotes) == 0 {
+		return RateEstimate{}, ErrNoRateData
+	}
+
+	now := time.Now()
+	var recent []RateQuote
+	for _, q := range e.quotes {
+		if now.Sub(q.At) <= e.maxStaleness {
+			recent = append(recent, q)
+		}
+	}
+	if len(recent) == 0 {
+		newest := e.quotes[len(e.quotes)-1]
+		return RateEstimate{}, fmt.Errorf("%w (última cotação há %v, limite %v)", ErrRateTooStale, now.Sub(newest.At).Round(time.Second), e.maxStaleness)
+	}
+
+	if e.outlierThreshold > 0 {
+		// se nenhuma cotação sobrar não há como saber quais são os outliers: usa todas
+		if kept := rejectOutliers(recent, e.outlierThreshold); len(kept) > 0 {
+			recent = kept
+		}
+	}
+
+	return RateEstimate{
+		Value:    e.strategy.Estimate(recent),
+		Strategy: e.strategy.Name(),
+		Age:      now.Sub(recent[len(recent)-1].At),
+		Samples:  len(recent),
+	}, nil
+}
+
+var dolarRates *RateEstimator
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func quotesOf(values ...float64) []RateQuote {
+	now := time.Now()
+	quotes := make([]RateQuote, len(values))
+	for i, v := range values {
+		quotes[i] = RateQuote{Value: v, At: now.Add(time.Duration(i-len(values)) * time.Second)}
+	}
+	return quotes
+}
+
+func TestRejectOutliers(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    []float64
+		threshold float64
+		want      []float64
+	}{
+		{"poucas cotações", []float64{1, 100}, 3, []float64{1, 100}},
+		{"sem dispersão", []float64{5, 5, 5, 50}, 3, []float64{5, 5, 5, 50}},
+		{"descarta pico", []float64{5, 5.1, 4.9, 5, 50}, 3, []float64{5, 5.1, 4.9, 5}},
+		{"limite abaixo de 1 descarta todas", []float64{1, 2, 10, 11}, 0.5, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept := rejectOutliers(quotesOf(tt.values...), tt.threshold)
+			if len(kept) != len(tt.want) {
+				t.Fatalf("rejectOutliers = %v, want %v", kept, tt.want)
+			}
+			for i, q := range kept {
+				if q.Value != tt.want[i] {
+					t.Fatalf("rejectOutliers[%d] = %v, want %v", i, q.Value, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRateEstimatorFallsBackWhenEveryQuoteIsAnOutlier(t *testing.T) {
+	strategies := []struct {
+		strategy RateStrategy
+		want     float64
+	}{
+		{EWMAStrategy{Alpha: 0.5}, 8.375},
+		{MedianStrategy{}, 6},
+		{LastKnownGoodStrategy{}, 11},
+	}
+	for _, tt := range strategies {
+		t.Run(tt.strategy.Name(), func(t *testing.T) {
+			// o construtor recusa limites abaixo de 1; aqui o filtro é forçado a descartar todas as cotações
+			e := &RateEstimator{
+				quotes:           quotesOf(1, 2, 10, 11),
+				capacity:         10,
+				maxStaleness:     time.Minute,
+				outlierThreshold: 0.5,
+				strategy:         tt.strategy,
+			}
+			estimate, err := e.Estimate()
+			if err != nil {
+				t.Fatalf("Estimate: %v", err)
+			}
+			if estimate.Samples != 4 || math.Abs(estimate.Value-tt.want) > 1e-9 {
+				t.Fatalf("Estimate = %+v, want valor %v com 4 amostras", estimate, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRateStrategyAlpha(t *testing.T) {
+	tests := []struct {
+		alpha   float64
+		wantErr bool
+	}{
+		{0.3, false},
+		{1, false},
+		{0, true},
+		{-0.2, true},
+		{1.5, true},
+		{math.NaN(), true},
+	}
+	for _, tt := range tests {
+		_, err := NewRateStrategy("ewma", tt.alpha)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewRateStrategy(ewma, %v) err = %v, wantErr %v", tt.alpha, err, tt.wantErr)
+		}
+	}
+
+	// o alpha só vale para a estratégia ewma
+	if _, err := NewRateStrategy("median", 0); err != nil {
+		t.Errorf("NewRateStrategy(median, 0) err = %v", err)
+	}
+}
+
+func TestNewRateEstimatorOutlierThreshold(t *testing.T) {
+	tests := []struct {
+		threshold float64
+		wantErr   bool
+	}{
+		{0, false},
+		{-1, false},
+		{1, false},
+		{3, false},
+		{0.5, true},
+		{0.99, true},
+	}
+	for _, tt := range tests {
+		_, err := NewRateEstimator(RateEstimatorConfig{Strategy: "median", Size: 5, OutlierThreshold: tt.threshold})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewRateEstimator(threshold %v) err = %v, wantErr %v", tt.threshold, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type HealthState int
+
+const (
+	HealthHealthy HealthState = iota
+	HealthUnhealthy
+	HealthRecovering
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case HealthHealthy:
+		return "HEALTHY"
+	case HealthUnhealthy:
+		return "UNHEALTHY"
+	case HealthRecovering:
+		return "RECOVERING"
+	}
+	return "UNKNOWN"
+}
+
+// Saúde do Fidelity vista pela entrega de bônus, verificada pelo GET /healthcheck
+// HEALTHY    -> entregas liberadas; uma falha de probe (ou de conexão numa entrega) pausa as entregas
+// UNHEALTHY  -> entregas pausadas; o probe continua até o Fidelity responder
+// RECOVERING -> entregas liberadas com vazão controlada: o intervalo mínimo entre entregas cai
+// de RampUpStartInterval até zero ao longo de RampUp, e então volta para HEALTHY
+type FidelityHealth struct {
+	mu sync.Mutex
+
+	url                 string
+	interval            time.Duration
+	timeout             time.Duration
+	rampUp              time.Duration
+	rampUpStartInterval time.Duration
+
+	state       HealthState
+	since       time.Time
+	lastError   string
+	lastRelease time.Time     // última entrega liberada durante a retomada
+	changed     chan struct{} // fechado (e recriado) a cada mudança de estado
+	done        chan struct{}
+}
+
+type FidelityHealthStatus struct {
+	State     string    `json:"state"`
+	Since     time.Time `json:"since"`
+	LastError string    `json:"lastError,omitempty"`
+	RampUp    string    `json:"rampUp"`
+}
+
+func NewFidelityHealth(url string, c HealthConfig) *FidelityHealth {
+	return &FidelityHealth{
+		url:                 url,
+		interval:            c.Interval,
+		timeout:             c.Timeout,
+		rampUp:              c.RampUp,
+		rampUpStartInterval: c.RampUpStartInterval,
+		state:               HealthHealthy,
+		since:               time.Now(),
+		changed:             make(chan struct{}),
+		done:                make(chan struct{}),
+	}
+}
+
+func (h *FidelityHealth) Start() {
+	go h.probeLoop()
+}
+
+func (h *FidelityHealth) probeLoop() {
+	ticker := time.NewTicker(max(h.interval, 100*time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := h.probe(); err != nil {
+				h.ReportFailure(err)
+			} else {
+				h.reportHealthy()
+			}
+		case <-h.done:
+			return
+		}
+	}
+}
+
+func (h *FidelityHealth) probe() error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", h.url+"/healthcheck", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &HTTPStatusError{Service: "Fidelity", StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// Deve ser chamado com h.mu travado
+func (h *FidelityHealth) transition(to HealthState, reason string) {
+	if h.state == to {
+		return
+	}
+	log.Printf("[FidelityHealth] %s -> %s após %v: %s", h.state, to, time.Since(h.since).Round(time.Second), reason)
+	h.state = to
+	h.since = time.Now()
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// ReportFailure pausa as entregas. Também é chamado pelos workers quando uma entrega falha por conexão.
+func (h *FidelityHealth) ReportFailure(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastError = err.Error()
+	h.transition(HealthUnhealthy, fmt.Sprintf("Fidelity inacessível (%v), pausando entregas de bônus", err))
+}
+
+func (h *FidelityHealth) reportHealthy() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.state == HealthUnhealthy {
+		h.lastRelease = time.Time{}
+		h.transition(HealthRecovering, fmt.Sprintf("Fidelity respondeu ao healthcheck, retomando entregas em %v", h.rampUp))
+	}
+}
+
+// Deve ser chamado com h.mu travado. Intervalo mínimo entre entregas no momento.
+func (h *FidelityHealth) releaseInterval(now time.Time) time.Duration {
+	if h.state != HealthRecovering {
+		return 0
+	}
+	elapsed := now.Sub(h.since)
+	if elapsed >= h.rampUp {
+		h.transition(HealthHealthy, "retomada concluída")
+		return 0
+	}
+	remaining := float64(h.rampUp-elapsed) / float64(h.rampUp)
+	return time.Duration(float64(h.rampUpStartInterval) * remaining)
+}
+
+// Acquire bloqueia enquanto as entregas estão pausadas ou, na retomada, até chegar a vez da próxima entrega.
+// Retorna false quando o monitor é fechado.
+func (h *FidelityHealth) Acquire() bool {
+	for {
+		h.mu.Lock()
+		now := time.Now()
+		var wait time.Duration
+		switch h.state {
+		case HealthHealthy:
+			h.mu.Unlock()
+			return true
+		case HealthRecovering:
+			next := h.lastRelease.Add(h.releaseInterval(now))
+			if !next.After(now) {
+				h.lastRelease = now
+				h.mu.Unlock()
+				return true
+			}
+			wait = next.Sub(now)
+		}
+		changed := h.changed
+		h.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case <-changed:
+		case <-timeout:
+		case <-h.done:
+			return false
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Paused indica se as entregas estão pausadas (Fidelity fora do ar)
+func (h *FidelityHealth) Paused() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state == HealthUnhealthy
+}
+
+func (h *FidelityHealth) Status() FidelityHealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.releaseInterval(time.Now())
+	return FidelityHealthStatus{
+		State:     h.state.String(),
+		Since:     h.since,
+		LastError: h.lastError,
+		RampUp:    h.rampUp.String(),
+	}
+}
+
+func (h *FidelityHealth) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	select {
+	case <-h.done:
+	default:
+		close(h.done)
+	}
+	return nil
+}
+
+// Falhas de entrega que indicam o Fidelity fora do ar (e não um problema do bônus).
+// Timeouts não entram: na compra eles costumam vir do prazo da requisição, não do Fidelity.
+func isFidelityUnreachable(err error) bool {
+	return isConnectionError(err)
+}
+
+var fidelityHealth = NewFidelityHealth(cfg.URL.Fidelity, cfg.BonusQueue.Health)
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrHoldExpired   = errors.New("reserva do assento expirada")
+	ErrHoldConfirmed = errors.New("reserva do assento já confirmada por outra compra")
+)
+
+// Reserva de assento (hold) no AirlinesHub: o assento e o preço ficam travados até ExpiresAt
+type SeatHold struct {
+	ID            string    `json:"id"`
+	Flight        string    `json:"flight"`
+	Day           string    `json:"day"`
+	Value         float64   `json:"value"`
+	FareClass     string    `json:"fareClass"`
+	Status        string    `json:"status"`
+	TransactionID string    `json:"transactionID,omitempty"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+}
+
+// Resposta 409 do AirlinesHub. O campo error diz o motivo: SOLD_OUT (voo lotado), HOLD_EXPIRED
+// (hold expirado ou liberado), HOLD_CONFIRMED (hold do Idempotency-Key já vendido) ou SALE_CANCELLED
+// (venda do Idempotency-Key já cancelada). Sem ele, o 409 é uma venda com o mesmo Idempotency-Key em andamento.
+type ConflictResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// Erro de uma resposta não-OK do AirlinesHub, distinguindo os 409 de voo lotado e de hold expirado
+func airlinesHubError(statusCode int, body []byte) error {
+	statusErr := &HTTPStatusError{Service: "AirlinesHub", StatusCode: statusCode, Message: string(bytes.TrimSpace(body))}
+	if statusCode != http.StatusConflict {
+		return statusErr
+	}
+
+	var conflict ConflictResponse
+	if json.Unmarshal(body, &conflict) != nil {
+		return statusErr
+	}
+	switch conflict.Error {
+	case "SOLD_OUT":
+		statusErr.Message = conflict.Message
+		return fmt.Errorf("%w: %w", ErrFlightSoldOut, statusErr)
+	case "HOLD_EXPIRED":
+		statusErr.Message = conflict.Message
+		return fmt.Errorf("%w: %w", ErrHoldExpired, statusErr)
+	case "HOLD_CONFIRMED":
+		statusErr.Message = conflict.Message
+		return fmt.Errorf("%w: %w", ErrHoldConfirmed, statusErr)
+	case "SALE_CANCELLED":
+		statusErr.Message = conflict.Message
+	}
+	return statusErr
+}
+
+func doHoldRequest(ctx context.Context, ft bool, method, endpoint, idempotencyKey string, body any) (SeatHold, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqData, err := json.Marshal(body)
+		if err != nil {
+			return SeatHold{}, fmt.Errorf("falha ao serializar request body: %w", err)
+		}
+		reqBody = bytes.NewBuffer(reqData)
+	}
+
+	req, err := newRequest(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return SeatHold{}, fmt.Errorf("falha ao montar requisição %s para %s: %w", method, endpoint, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set(IdempotencyKeyHeader, idempotencyKey)
+	}
+
+	var resp *http.Response
+	if ft {
+		resp, err = ftHttpClient.Do(req)
+	} else {
+		resp, err = client.Do(req)
+	}
+	if err != nil {
+		log.Printf("ERRO: falha ao enviar requisição %s para %s: %v", method, endpoint, err)
+		return SeatHold{}, fmt.Errorf("falha ao enviar requisição %s para %s: %w", method, endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		log.Printf("ERRO: servidor retornou status %d: %s", resp.StatusCode, string(bodyBytes))
+		return SeatHold{}, airlinesHubError(resp.StatusCode, bodyBytes)
+	}
+
+	var hold SeatHold
+	if err := json.NewDecoder(resp.Body).Decode(&hold); err != nil {
+		return SeatHold{}, fmt.Errorf("falha ao decodificar resposta JSON: %w", err)
+	}
+	return hold, nil
+}
+
+// Reserva um assento do voo no AirlinesHub. A reserva é idempotente pelo Idempotency-Key,
+// então pode ser repetida com segurança (ao contrário do /sell).
+func HoldSeat(ctx context.Context, ft bool, flight, day, idempotencyKey string) (SeatHold, error) {
+	log.Printf("Reservando assento no voo %s, dia %s", flight, day)
+
+	endpoint := fmt.Sprintf("%s/holds", cfg.URL.AirlinesHub)
+	request := FlightRequest{Flight: flight, Day: day}
+	if !ft {
+		return doHoldRequest(ctx, ft, "POST", endpoint, idempotencyKey, request)
+	}
+	return callWithBreaker(ctx, breakers.Sell, func() (SeatHold, error) {
+		return retry(ctx, retryPolicies.Hold, func() (SeatHold, error) {
+			return doHoldRequest(ctx, ft, "POST", endpoint, idempotencyKey, request)
+		})
+	})
+}
+
+// Confirma o hold, transformando a reserva em venda, e retorna o transactionID da venda.
+// A confirmação é idempotente: repeti-la devolve a mesma venda.
+func ConfirmHold(ctx context.Context, ft bool, holdID string) (uuid.UUID, error) {
+	log.Printf("Confirmando reserva %s no AirlinesHub", holdID)
+
+	endpoint := fmt.Sprintf("%s/holds/%s/confirm", cfg.URL.AirlinesHub, holdID)
+	confirm := func() (SeatHold, error) {
+		hold, err := doHoldRequest(ctx, ft, "POST", endpoint, "", nil)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			log.Println("Falha na requisição devido a alta latência. Timeout de 2s excedido.")
+			return hold, ErrTicketSellTimeout
+		}
+		return hold, err
+	}
+
+	var hold SeatHold
+	var err error
+	if ft {
+		hold, err = callWithBreaker(ctx, breakers.Sell, func() (SeatHold, error) {
+			return retry(ctx, retryPolicies.Hold, confirm)
+		})
+	} else {
+		hold, err = confirm()
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	transactionID, err := uuid.Parse(hold.TransactionID)
+	if err != nil {
+		log.Printf("ERRO: servidor retornou um transactionID inválido (%s): %v", hold.TransactionID, err)
+		return uuid.Nil, fmt.Errorf("servidor retornou um transactionID inválido: %w", err)
+	}
+	return transactionID, nil
+}
+
+// Consulta o hold no AirlinesHub (found=false se ele não existe)
+func GetHold(ctx context.Context, holdID string) (SeatHold, bool, error) {
+	endpoint := fmt.Sprintf("%s/holds/%s", cfg.URL.AirlinesHub, holdID)
+	hold, err := doHoldRequest(ctx, true, "GET", endpoint, "", nil)
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return SeatHold{}, false, nil
+	}
+	if err != nil {
+		return SeatHold{}, false, err
+	}
+	return hold, true, nil
+}
+
+// Libera o hold no AirlinesHub, devolvendo o assento. Se o hold já foi confirmado, a venda é cancelada.
+// A liberação é idempotente e um hold desconhecido não tem nada a liberar.
+func ReleaseHold(ctx context.Context, holdID string) error {
+	log.Printf("Liberando reserva %s no AirlinesHub", holdID)
+
+	endpoint := fmt.Sprintf("%s/holds/%s/release", cfg.URL.AirlinesHub, holdID)
+	hold, err := doHoldRequest(ctx, true, "POST", endpoint, "", nil)
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		log.Printf("AVISO: reserva %s não existe no AirlinesHub, nada a liberar", holdID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Reserva %s liberada no AirlinesHub (%s)", holdID, hold.Status)
+	return nil
+}
+
+// Libera em segundo plano o hold de uma compra que falhou antes da saga começar.
+// Se a liberação falhar, o hold expira sozinho no AirlinesHub.
+func releaseAbandonedHold(holdID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := ReleaseHold(ctx, holdID); err != nil {
+		log.Printf("AVISO: falha ao liberar reserva %s, ela vai expirar no AirlinesHub: %v", holdID, err)
+	}
+}
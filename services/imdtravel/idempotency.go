@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fsousabt/shared/journal"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+type idempotencyRecord struct {
+	Key         string    `json:"key"`
+	RequestHash string    `json:"requestHash"`
+	StatusCode  int       `json:"statusCode"`
+	ContentType string    `json:"contentType"`
+	Body        []byte    `json:"body"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+type idempotencyResult int
+
+const (
+	idempotencyStarted    idempotencyResult = iota // primeira requisição com a chave
+	idempotencyReplay                              // já concluída: devolver a resposta original
+	idempotencyInProgress                          // a primeira requisição ainda está em andamento
+	idempotencyMismatch                            // mesma chave com outro corpo de requisição
+)
+
+// Guarda as respostas das requisições com Idempotency-Key até expirarem (ttl).
+// As respostas concluídas são gravadas em um journal para sobreviver a reinícios.
+type IdempotencyStore struct {
+	mu sync.Mutex
+
+	ttl           time.Duration
+	inFlight      map[string]string // chave -> hash do corpo da requisição
+	completed     map[string]idempotencyRecord
+	journal       *journal.Journal
+	snapshotEvery int
+}
+
+func NewIdempotencyStore(c IdempotencyConfig) (*IdempotencyStore, error) {
+	journal, err := journal.Open(c.Dir, "idempotency", c.Journal)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &IdempotencyStore{
+		ttl:           c.TTL,
+		inFlight:      make(map[string]string),
+		completed:     make(map[string]idempotencyRecord),
+		journal:       journal,
+		snapshotEvery: max(c.SnapshotEvery, 1),
+	}
+
+	now := time.Now()
+	err = journal.Load(func(data []byte) error {
+		var record idempotencyRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		if record.ExpiresAt.After(now) {
+			s.completed[record.Key] = record
+		}
+		return nil
+	})
+	if err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("falha ao recuperar chaves de idempotência: %w", err)
+	}
+
+	go s.sweepLoop()
+	return s, nil
+}
+
+func (s *IdempotencyStore) Begin(key, requestHash string) (idempotencyRecord, idempotencyResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.completed[key]; ok && record.ExpiresAt.After(time.Now()) {
+		if record.RequestHash != requestHash {
+			return record, idempotencyMismatch
+		}
+		return record, idempotencyReplay
+	}
+
+	if hash, ok := s.inFlight[key]; ok {
+		if hash != requestHash {
+			return idempotencyRecord{}, idempotencyMismatch
+		}
+		return idempotencyRecord{}, idempotencyInProgress
+	}
+
+	s.inFlight[key] = requestHash
+	return idempotencyRecord{}, idempotencyStarted
+}
+
+func (s *IdempotencyStore) Complete(key string, statusCode int, contentType string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := idempotencyRecord{
+		Key:         key,
+		RequestHash: s.inFlight[key],
+		StatusCode:  statusCode,
+		ContentType: contentType,
+		Body:        body,
+		ExpiresAt:   time.Now().Add(s.ttl),
+	}
+	delete(s.inFlight, key)
+	s.completed[key] = record
+
+	if err := s.journal.Append(record); err != nil {
+		log.Printf("[Idempotency] ERRO: falha ao gravar resposta da chave %s: %v", key, err)
+	}
+	if s.journal.Records() >= s.snapshotEvery {
+		s.snapshot()
+	}
+}
+
+// Abandon libera a chave sem guardar resposta, permitindo que o cliente tente novamente
+func (s *IdempotencyStore) Abandon(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inFlight, key)
+}
+
+// Deve ser chamado com s.mu travado
+func (s *IdempotencyStore) snapshot() {
+	records := make([]any, 0, len(s.completed))
+	for _, record := range s.completed {
+		records = append(records, record)
+	}
+	if err := s.journal.Snapshot(records); err != nil {
+		log.Printf("[Idempotency] ERRO: falha ao gravar snapshot: %v", err)
+	}
+}
+
+func (s *IdempotencyStore) sweepLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		now := time.Now()
+		expired := 0
+		for key, record := range s.completed {
+			if !record.ExpiresAt.After(now) {
+				delete(s.completed, key)
+				expired++
+			}
+		}
+		s.mu.Unlock()
+
+		if expired > 0 {
+			log.Printf("[Idempotency] %d chave(s) expirada(s) removida(s)", expired)
+		}
+	}
+}
+
+func (s *IdempotencyStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshot()
+	return s.journal.Close()
+}
+
+var idempotencyStore *IdempotencyStore
+
+// Guarda a resposta escrita pelo handler para poder repeti-la
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+	written    bool // o handler respondeu (header ou corpo)
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.written = true
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.written = true
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+// Chave repassada ao AirlinesHub para a compra do usuário feita com o Idempotency-Key key. A chave do
+// cliente é combinada com o usuário para que compras de usuários diferentes com a mesma chave não
+// compartilhem a reserva e a venda no AirlinesHub.
+func scopedIdempotencyKey(user, key string) string {
+	sum := sha256.Sum256([]byte(user + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Respostas de erro que com certeza não venderam a passagem (5xx, exceto 504) não são guardadas,
+// para que o cliente possa tentar novamente com a mesma chave.
+func shouldStoreResponse(statusCode int) bool {
+	return statusCode < 500 || statusCode == http.StatusGatewayTimeout
+}
+
+// Middleware de idempotência: requisições repetidas com o mesmo Idempotency-Key recebem a resposta
+// original (ou 409 enquanto a primeira ainda está em andamento) em vez de serem processadas de novo.
+func withIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, newAPIError(http.StatusBadRequest, fmt.Errorf("falha ao ler corpo da requisição: %w", err)))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		requestHash := hex.EncodeToString(sum[:])
+
+		record, result := idempotencyStore.Begin(key, requestHash)
+		switch result {
+		case idempotencyReplay:
+			log.Printf("[Idempotency] Chave %s já processada, devolvendo resposta original (%d)", key, record.StatusCode)
+			w.Header().Set("Content-Type", record.ContentType)
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			w.WriteHeader(record.StatusCode)
+			w.Write(record.Body)
+			return
+		case idempotencyInProgress:
+			log.Printf("[Idempotency] Chave %s ainda em processamento", key)
+			writeError(w, newAPIError(http.StatusConflict, errors.New("requisição com este Idempotency-Key ainda está em processamento")))
+			return
+		case idempotencyMismatch:
+			writeError(w, newAPIError(http.StatusUnprocessableEntity, errors.New("Idempotency-Key já usado com outro corpo de requisição")))
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		// um panic no handler também libera a chave; sem isso ela ficaria em andamento até o serviço reiniciar
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("[Idempotency] Chave %s liberada após panic no handler: %v", key, p)
+				idempotencyStore.Abandon(key)
+				panic(p)
+			}
+		}()
+		next(recorder, r)
+
+		// sem resposta (cliente desistiu antes de uma etapa) não há o que repetir: a chave é liberada
+		if !recorder.written {
+			log.Printf("[Idempotency] Chave %s sem resposta do handler, liberando para nova tentativa", key)
+			idempotencyStore.Abandon(key)
+		} else if shouldStoreResponse(recorder.statusCode) {
+			idempotencyStore.Complete(key, recorder.statusCode, recorder.Header().Get("Content-Type"), recorder.body.Bytes())
+		} else {
+			idempotencyStore.Abandon(key)
+		}
+	}
+}
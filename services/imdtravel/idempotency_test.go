@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsousabt/shared/journal"
+)
+
+func openTestIdempotencyStore(t *testing.T) {
+	t.Helper()
+	store, err := NewIdempotencyStore(IdempotencyConfig{
+		TTL:     time.Hour,
+		Dir:     t.TempDir(),
+		Journal: journal.Config{Sync: journal.SyncNever},
+	})
+	if err != nil {
+		t.Fatalf("NewIdempotencyStore: %v", err)
+	}
+	previous := idempotencyStore
+	idempotencyStore = store
+	t.Cleanup(func() {
+		idempotencyStore = previous
+		store.Close()
+	})
+}
+
+func TestWithIdempotencyReleasesOrStoresTheKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantReplay bool
+	}{
+		{"resposta de sucesso é guardada", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, map[string]string{"transactionID": "t1"})
+		}, true},
+		{"erro do servidor libera a chave", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "falhou", http.StatusInternalServerError)
+		}, false},
+		{"sem resposta libera a chave", func(w http.ResponseWriter, r *http.Request) {}, false},
+		{"panic libera a chave", func(w http.ResponseWriter, r *http.Request) {
+			panic("falha inesperada")
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestIdempotencyStore(t)
+
+			send := func(handler http.HandlerFunc) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/buyTicket", strings.NewReader(`{"flight":"A1"}`))
+				req.Header.Set(IdempotencyKeyHeader, "chave-1")
+				w := httptest.NewRecorder()
+				func() {
+					defer func() { recover() }()
+					withIdempotency(handler)(w, req)
+				}()
+				return w
+			}
+
+			send(tt.handler)
+
+			retried := false
+			w := send(func(w http.ResponseWriter, r *http.Request) {
+				retried = true
+				writeJSON(w, http.StatusOK, map[string]string{"transactionID": "t2"})
+			})
+			if w.Code == http.StatusConflict {
+				t.Fatalf("chave ficou em andamento após a primeira requisição: %s", w.Body)
+			}
+			if replayed := w.Header().Get(IdempotentReplayedHeader) == "true"; replayed != tt.wantReplay || retried == tt.wantReplay {
+				t.Fatalf("replay = %v, handler executado de novo = %v; want replay %v", replayed, retried, tt.wantReplay)
+			}
+		})
+	}
+}
@@ -2,15 +2,19 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"math"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
@@ -22,14 +26,11 @@ var ftHttpClient = &http.Client{
 
 var client = &http.Client{}
 
-var flightCache = make(map[string]*FlightData)
-
-var dolarCache []float64 // guarda últimas cotações
-
 type BuyTicketRequest struct {
 	Flight string `json:"flight"`
 	Day    string `json:"day"`
 	User   string `json:"user"`
+	Points int    `json:"points,omitempty"` // pontos do Fidelity usados como desconto
 	FaultToleranceConfig
 }
 
@@ -38,12 +39,26 @@ type BuyTicketResponse struct {
 }
 
 type Ticket struct {
-	TransactionID uuid.NullUUID `json:"transactionID"`
-	FlightNumber  string        `json:"flight"`
-	FlightDay     string        `json:"day"`
-	Price         float64       `json:"price"`
-	UserID        string        `json:"user"`
-	Status        string        `json:"status"`
+	ID             uuid.UUID     `json:"id"`
+	TransactionID  uuid.NullUUID `json:"transactionID"`
+	FlightNumber   string        `json:"flight"`
+	FlightDay      string        `json:"day"`
+	HoldID         string        `json:"holdID,omitempty"` // reserva do assento no AirlinesHub
+	Price          float64       `json:"price"`
+	CashAmount     float64       `json:"cashAmount"`               // parte do preço paga em dinheiro
+	Points         int           `json:"points,omitempty"`         // pontos resgatados
+	PointsDiscount float64       `json:"pointsDiscount,omitempty"` // desconto em reais dos pontos resgatados
+	UserID         string        `json:"user"`
+	Ft             bool          `json:"ft"`              // compra feita com tolerância a falhas
+	Bonus          int           `json:"bonus,omitempty"` // pontos de bônus da compra
+	Status         string        `json:"status"`
+	ExchangeRate   float64       `json:"exchangeRate"`
+	RateStrategy   string        `json:"rateStrategy"`
+	RateAge        string        `json:"rateAge,omitempty"`
+	FailureReason  string        `json:"failureReason,omitempty"`
+	IdempotencyKey string        `json:"idempotencyKey"`
+	CreatedAt      time.Time     `json:"createdAt"`
+	UpdatedAt      time.Time     `json:"updatedAt"`
 }
 
 type FlightRequest struct {
@@ -71,8 +86,9 @@ type SellResponse struct {
 }
 
 type FidelityRequest struct {
-	User  string `json:"user"`
-	Bonus int    `json:"bonus"`
+	User          string `json:"user"`
+	Bonus         int    `json:"bonus"`
+	TransactionID string `json:"transactionID"` // chave de deduplicação no Fidelity
 }
 
 type APIError struct {
@@ -111,68 +127,121 @@ func writeJSON(w http.ResponseWriter, code int, payload any) {
 
 var ErrTicketSellTimeout = errors.New("Timeout excedido no processo de venda de passagem aérea.")
 
+var ErrFlightSoldOut = errors.New("voo esgotado")
+
 func cacheKey(flight, day string) string {
 	return flight + "|" + day
 }
 
-func avg(values []float64) float64 {
-	if len(values) == 0 {
-		return -1 // "não tem cache"
-	}
-	sum := 0.0
-	for _, v := range values {
-		sum += v
+var ticketDB TicketStore
+
+func saveTicket(ticket *Ticket) error {
+	ticket.UpdatedAt = time.Now()
+	if err := ticketDB.Save(*ticket); err != nil {
+		log.Printf("ERRO: falha ao armazenar ticket %s (%s): %v", ticket.ID, ticket.Status, err)
+		return err
 	}
-	return sum / float64(len(values))
+	return nil
 }
 
-var ticketDB = make(map[uuid.UUID]Ticket)
+func main() {
+	log.Println("Iniciando serviço IMDTravel...")
+	checkRequiredConfig(cfg)
 
-type PendingBonusQueue struct {
-	ch chan FidelityRequest
-}
+	var err error
+	dolarRates, err = NewRateEstimator(cfg.RateEstimator)
+	if err != nil {
+		log.Fatalf("Configuração inválida do estimador de cotação: %v", err)
+	}
 
-var pendingBonusQueue PendingBonusQueue
+	bonusRules, err = LoadBonusRules(cfg.BonusRules)
+	if err != nil {
+		log.Fatalf("Falha ao carregar regras de bônus: %v", err)
+	}
 
-func processPendingBonus(queue <-chan FidelityRequest) {
-	log.Println("[processPendingBonus] Iniciando Worker de processamento assincrono de bonus")
-	var seconds time.Duration = 1
-	for bonus := range queue {
-		log.Println("[processPendingBonus] enviando requisição para processar a bonificação de fidelidade")
-		_, err := trySendFidelityRequest(bonus.User, bonus.Bonus)
-		if err != nil {
-			log.Printf("[processPendingBonus] Falha ao processar bonus para %s. Devolvendo para a fila. Próxima tentativa em %ds", bonus.User, seconds)
-			pendingBonusQueue.ch <- bonus
-			if seconds < 60 {
-				seconds++
-			}
-		} else {
-			log.Printf("[processPendingBonus] Bonus para %s processado com sucesso.", bonus.User)
-			seconds = 1
-		}
-		time.Sleep(seconds * time.Second)
+	ticketDB, err = NewTicketStore(cfg.TicketStore)
+	if err != nil {
+		log.Fatalf("Falha ao abrir armazenamento de tickets: %v", err)
 	}
-}
 
-func main() {
-	log.Println("Iniciando serviço IMDTravel...")
+	idempotencyStore, err = NewIdempotencyStore(cfg.Idempotency)
+	if err != nil {
+		log.Fatalf("Falha ao abrir armazenamento de chaves de idempotência: %v", err)
+	}
 
-	log.Println("Criando fila para processamento de bonus assincrono")
-	pendingBonusQueue.ch = make(chan FidelityRequest, 100)
+	sagas, err = NewSagaCoordinator(cfg.Saga)
+	if err != nil {
+		log.Fatalf("Falha ao abrir saga log: %v", err)
+	}
+
+	reconciler, err = NewReconciler(cfg.Reconcile, sagas)
+	if err != nil {
+		log.Fatalf("Falha ao abrir auditoria de reconciliações: %v", err)
+	}
+
+	log.Println("Abrindo fila para processamento de bonus assincrono")
+	deadLetters, err = NewDeadLetterStore(cfg.BonusQueue)
+	if err != nil {
+		log.Fatalf("Falha ao abrir dead-letter de bônus: %v", err)
+	}
+	pendingBonusQueue, err = NewPendingBonusQueue(cfg.BonusQueue, deadLetters)
+	if err != nil {
+		log.Fatalf("Falha ao abrir fila de bônus pendentes: %v", err)
+	}
+	go waitForShutdown(ticketDB, idempotencyStore, reconciler, sagas, fidelityHealth, pendingBonusQueue, deadLetters)
+
+	fidelityHealth.Start()
 
-	log.Println("Iniciando Worker para processamento de bonus assincrono")
-	go processPendingBonus(pendingBonusQueue.ch)
+	log.Printf("Iniciando %d Worker(s) para processamento de bonus assincrono", pendingBonusQueue.workers)
+	for worker := range pendingBonusQueue.workers {
+		go processPendingBonus(pendingBonusQueue, worker)
+	}
+
+	log.Println("Retomando compras interrompidas")
+	sagas.Recover()
+
+	log.Println("Iniciando reconciliação das vendas incertas")
+	reconciler.Start()
 
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("GET /healthcheck", healthCheckHandler)
-	mux.HandleFunc("POST /buyTicket", buyTicketHandler)
+	mux.HandleFunc("POST /buyTicket", withIdempotency(buyTicketHandler))
+	mux.HandleFunc("GET /tickets/{transactionID}", getTicketHandler)
+	mux.HandleFunc("POST /tickets/{transactionID}/cancel", cancelTicketHandler)
+	mux.HandleFunc("GET /users/{user}/tickets", listUserTicketsHandler)
+	mux.HandleFunc("GET /circuitBreakers", circuitBreakersHandler)
+	mux.HandleFunc("GET /retryPolicies", retryPoliciesHandler)
+	mux.HandleFunc("GET /bonusQueue", bonusQueueHandler)
+	mux.HandleFunc("GET /admin/reconciliations", listReconciliationsHandler)
+	mux.HandleFunc("GET /admin/reconciliations/{ticketID}", getReconciliationHandler)
+	mux.HandleFunc("GET /admin/deadLetters", listDeadLettersHandler)
+	mux.HandleFunc("POST /admin/deadLetters/redrive", redriveAllDeadLettersHandler)
+	mux.HandleFunc("DELETE /admin/deadLetters", purgeAllDeadLettersHandler)
+	mux.HandleFunc("GET /admin/deadLetters/{id}", getDeadLetterHandler)
+	mux.HandleFunc("POST /admin/deadLetters/{id}/redrive", redriveDeadLetterHandler)
+	mux.HandleFunc("DELETE /admin/deadLetters/{id}", purgeDeadLetterHandler)
 
 	port := ":80"
 	log.Printf("Serviço IMDTravel rodando na porta %s", port[1:])
 	log.Fatal(http.ListenAndServe(port, mux))
 }
 
+// Fecha os recursos persistentes (fazendo o fsync pendente) quando o container é parado
+func waitForShutdown(closers ...io.Closer) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+
+	log.Printf("Sinal %v recebido, encerrando serviço IMDTravel...", sig)
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			log.Printf("ERRO: falha ao fechar recurso: %v", err)
+		}
+	}
+	os.Exit(0)
+}
+
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -188,31 +257,7 @@ func parseDate(day string) error {
 	return nil
 }
 
-// Função De Retry
-// T = tipo de retorno (aqui será *FlightData)
-// attempts = quantas tentativas
-// fn = função a ser chamada
-func retry[T any](attempts int, fn func() (T, error)) (T, error) {
-	var zero T
-	var err error
-
-	for i := 0; i < attempts; i++ {
-		var result T
-		result, err = fn()
-		if err == nil {
-			return result, nil
-		}
-
-		// backoff exponencial simples
-		sleep := time.Duration(math.Pow(2, float64(i))) * 200 * time.Millisecond
-		log.Printf("Tentativa %d falhou: %v — retry em %v", i+1, err, sleep)
-		time.Sleep(sleep)
-	}
-
-	return zero, err
-}
-
-func GetFlight(ft bool, flight string, day string) (*FlightData, error) {
+func GetFlight(ctx context.Context, ft bool, flight string, day string) (*FlightData, error) {
 	log.Printf("Iniciando busca por voo %s, dia %s", flight, day)
 
 	endpoint := fmt.Sprintf("%s/flight?flight=%s&day=%s",
@@ -221,7 +266,7 @@ func GetFlight(ft bool, flight string, day string) (*FlightData, error) {
 		day,
 	)
 
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := newRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		log.Printf("ERRO: falha ao criar requisição para AirlinesHub: %v", err)
 		return nil, fmt.Errorf("falha ao criar requisição para %s: %w", endpoint, err)
@@ -240,6 +285,12 @@ func GetFlight(ft bool, flight string, day string) (*FlightData, error) {
 	}
 	defer response.Body.Close()
 
+	if response.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		log.Printf("ERRO: Serviço AirlinesHub retornou status não-OK %d: %s", response.StatusCode, string(bodyBytes))
+		return nil, &HTTPStatusError{Service: "AirlinesHub", StatusCode: response.StatusCode, Message: string(bodyBytes)}
+	}
+
 	var flightData FlightData
 	if err := json.NewDecoder(response.Body).Decode(&flightData); err != nil {
 		log.Printf("ERRO: falha ao decodificar resposta do AirlinesHub: %v", err)
@@ -247,15 +298,24 @@ func GetFlight(ft bool, flight string, day string) (*FlightData, error) {
 	}
 
 	log.Printf("Sucesso: Voo encontrado: %+v", flightData)
-	flightCache[cacheKey(flight, day)] = &flightData
+	flightCache.Set(cacheKey(flight, day), &flightData)
 	return &flightData, nil
 }
 
-func getDolarValueInReal(ft bool) (float64, error) {
+// Busca o voo no AirlinesHub protegida por circuit breaker e retry
+func fetchFlight(ctx context.Context, flight string, day string) (*FlightData, error) {
+	return callWithBreaker(ctx, breakers.Flight, func() (*FlightData, error) {
+		return retry(ctx, retryPolicies.Flight, func() (*FlightData, error) {
+			return GetFlight(ctx, true, flight, day)
+		})
+	})
+}
+
+func getDolarValueInReal(ctx context.Context, ft bool) (float64, error) {
 	log.Println("Iniciando busca por cotação do dólar")
 
 	endpoint := fmt.Sprintf("%s/convert", cfg.URL.Exchange)
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := newRequest(ctx, "GET", endpoint, nil)
 
 	if err != nil {
 		log.Printf("ERRO: falha ao criar requisição para Exchange: %v", err)
@@ -279,11 +339,11 @@ func getDolarValueInReal(ft bool) (float64, error) {
 
 		if err := json.Unmarshal(bodyBytes, &errMsg); err == nil && errMsg.Message != "" {
 			log.Printf("ERRO: Serviço Exchange retornou status %d: %s", response.StatusCode, errMsg.Message)
-			return -1, fmt.Errorf("serviço Exchange falhou, %s", errMsg.Message)
+			return -1, &HTTPStatusError{Service: "Exchange", StatusCode: response.StatusCode, Message: errMsg.Message}
 		}
 
 		log.Printf("ERRO: Serviço Exchange retornou status não-OK %d: %s", response.StatusCode, string(bodyBytes))
-		return -1, fmt.Errorf("serviço Exchange retornou status não-OK %d", response.StatusCode)
+		return -1, &HTTPStatusError{Service: "Exchange", StatusCode: response.StatusCode}
 	}
 
 	var exchangeResponse ExchangeToDolarResponse
@@ -295,18 +355,15 @@ func getDolarValueInReal(ft bool) (float64, error) {
 	log.Printf("Sucesso: Cotação do dólar obtida: %.2f", exchangeResponse.Value)
 
 	if ft {
-		// Atualiza cache mantendo os últimos 10 valores
-		dolarCache = append(dolarCache, exchangeResponse.Value)
-		if len(dolarCache) > 10 {
-			dolarCache = dolarCache[len(dolarCache)-10:]
-		}
+		dolarRates.Record(exchangeResponse.Value)
 	}
 
 	return exchangeResponse.Value, nil
 
 }
 
-func RequestTicketSell(ft bool, flight string, day string) (uuid.UUID, error) {
+// idempotencyKey é repassado ao AirlinesHub, que devolve a mesma venda para requisições repetidas
+func RequestTicketSell(ctx context.Context, ft bool, flight string, day string, idempotencyKey string) (uuid.UUID, error) {
 	log.Printf("Iniciando requisição de venda para voo %s, dia %s\n", flight, day)
 
 	endpoint := fmt.Sprintf("%s/sell", cfg.URL.AirlinesHub)
@@ -321,11 +378,13 @@ func RequestTicketSell(ft bool, flight string, day string) (uuid.UUID, error) {
 		return uuid.Nil, fmt.Errorf("falha ao serializar request body: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer([]byte(reqData)))
+	req, err := newRequest(ctx, "POST", endpoint, bytes.NewBuffer([]byte(reqData)))
 	if err != nil {
 		log.Printf("ERRO: falha ao montar requisição POST para %s: %v\n", endpoint, err)
 		return uuid.Nil, fmt.Errorf("falha ao montar requisição POST para %s: %w", endpoint, err)
 	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IdempotencyKeyHeader, idempotencyKey)
 
 	var resp *http.Response
 	if ft {
@@ -347,7 +406,7 @@ func RequestTicketSell(ft bool, flight string, day string) (uuid.UUID, error) {
 	if resp.StatusCode != 200 {
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		log.Printf("ERRO: servidor retornou status %d: %s\n", resp.StatusCode, string(bodyBytes))
-		return uuid.Nil, fmt.Errorf("servidor retornou status não-OK %d: %s", resp.StatusCode, string(bodyBytes))
+		return uuid.Nil, airlinesHubError(resp.StatusCode, bodyBytes)
 	}
 
 	var responsePayload SellResponse
@@ -365,22 +424,129 @@ func RequestTicketSell(ft bool, flight string, day string) (uuid.UUID, error) {
 	return transactionUUID, nil
 }
 
-func trySendFidelityRequest(userID string, bonus int) (int, error) {
-	log.Printf("Iniciando requisição de bônus para usuário %s, valor %d", userID, bonus)
+// Procura no AirlinesHub a venda feita com idempotencyKey (found=false se a venda não aconteceu)
+func FindSaleByIdempotencyKey(ctx context.Context, idempotencyKey string) (uuid.UUID, bool, error) {
+	log.Printf("Buscando venda com Idempotency-Key %s", idempotencyKey)
 
-	endpoint := fmt.Sprintf("%s/bonus", cfg.URL.Fidelity)
-	reqBody := FidelityRequest{
-		User:  userID,
-		Bonus: bonus,
+	endpoint := fmt.Sprintf("%s/transactions?idempotencyKey=%s", cfg.URL.AirlinesHub, url.QueryEscape(idempotencyKey))
+	req, err := newRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return uuid.Nil, false, fmt.Errorf("falha ao criar requisição para %s: %w", endpoint, err)
+	}
+
+	resp, err := ftHttpClient.Do(req)
+	if err != nil {
+		log.Printf("ERRO: falha ao buscar venda no AirlinesHub (%s): %v", endpoint, err)
+		return uuid.Nil, false, fmt.Errorf("falha ao fazer requisição para %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return uuid.Nil, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return uuid.Nil, false, &HTTPStatusError{Service: "AirlinesHub", StatusCode: resp.StatusCode, Message: string(bodyBytes)}
+	}
+
+	var sale SellResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sale); err != nil {
+		return uuid.Nil, false, fmt.Errorf("falha ao decodificar resposta JSON: %w", err)
 	}
 
+	transactionID, err := uuid.Parse(sale.TransactionID)
+	if err != nil {
+		return uuid.Nil, false, fmt.Errorf("servidor retornou um transactionID inválido: %w", err)
+	}
+	return transactionID, true, nil
+}
+
+// Venda registrada no AirlinesHub
+type AirlinesHubSale struct {
+	TransactionID string `json:"transactionID"`
+	Flight        string `json:"flight"`
+	Day           string `json:"day"`
+	Status        string `json:"status"` // SOLD ou CANCELLED
+}
+
+// Consulta a venda no AirlinesHub pelo transactionID (found=false se ela não existe)
+func GetSale(ctx context.Context, transactionID uuid.UUID) (AirlinesHubSale, bool, error) {
+	endpoint := fmt.Sprintf("%s/transactions/%s", cfg.URL.AirlinesHub, transactionID)
+	req, err := newRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return AirlinesHubSale{}, false, fmt.Errorf("falha ao criar requisição para %s: %w", endpoint, err)
+	}
+
+	resp, err := ftHttpClient.Do(req)
+	if err != nil {
+		log.Printf("ERRO: falha ao consultar venda no AirlinesHub (%s): %v", endpoint, err)
+		return AirlinesHubSale{}, false, fmt.Errorf("falha ao fazer requisição para %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return AirlinesHubSale{}, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return AirlinesHubSale{}, false, &HTTPStatusError{Service: "AirlinesHub", StatusCode: resp.StatusCode, Message: string(bodyBytes)}
+	}
+
+	var sale AirlinesHubSale
+	if err := json.NewDecoder(resp.Body).Decode(&sale); err != nil {
+		return AirlinesHubSale{}, false, fmt.Errorf("falha ao decodificar resposta JSON: %w", err)
+	}
+	return sale, true, nil
+}
+
+// Cancela a venda no AirlinesHub. O cancelamento é idempotente.
+func CancelTicketSell(ctx context.Context, transactionID uuid.UUID) error {
+	log.Printf("Cancelando venda %s no AirlinesHub", transactionID)
+
+	endpoint := fmt.Sprintf("%s/transactions/%s/cancel", cfg.URL.AirlinesHub, transactionID)
+	req, err := newRequest(ctx, "POST", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("falha ao montar requisição POST para %s: %w", endpoint, err)
+	}
+
+	resp, err := ftHttpClient.Do(req)
+	if err != nil {
+		log.Printf("ERRO: falha ao cancelar venda no AirlinesHub (%s): %v", endpoint, err)
+		return fmt.Errorf("falha ao enviar requisição POST para %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		log.Printf("AVISO: venda %s não existe no AirlinesHub, nada a cancelar", transactionID)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return &HTTPStatusError{Service: "AirlinesHub", StatusCode: resp.StatusCode, Message: string(bodyBytes)}
+	}
+
+	log.Printf("Venda %s cancelada no AirlinesHub", transactionID)
+	return nil
+}
+
+func trySendFidelityRequest(ctx context.Context, reqBody FidelityRequest) (int, error) {
+	log.Printf("Iniciando requisição de bônus para usuário %s, valor %d", reqBody.User, reqBody.Bonus)
+
+	endpoint := fmt.Sprintf("%s/bonus", cfg.URL.Fidelity)
 	reqData, err := json.Marshal(reqBody)
 	if err != nil {
 		log.Printf("ERRO: falha ao serializar request body do fidelity: %v", err)
 		return 0, fmt.Errorf("falha ao serializar request body: %w", err)
 	}
 
-	resp, err := http.Post(endpoint, "application/json", bytes.NewBuffer(reqData))
+	req, err := newRequest(ctx, "POST", endpoint, bytes.NewBuffer(reqData))
+	if err != nil {
+		log.Printf("ERRO: falha ao montar requisição POST para fidelity (%s): %v", endpoint, err)
+		return 0, fmt.Errorf("falha ao montar requisição POST para %s: %w", endpoint, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
 	if err != nil {
 		log.Printf("ERRO: falha ao enviar requisição POST para fidelity (%s): %v", endpoint, err)
 		return 0, fmt.Errorf("falha ao enviar requisição POST para %s: %w", endpoint, err)
@@ -389,16 +555,38 @@ func trySendFidelityRequest(userID string, bonus int) (int, error) {
 	defer resp.Body.Close()
 
 	log.Printf("Serviço Fidelity respondeu com status: %d", resp.StatusCode)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, &HTTPStatusError{Service: "Fidelity", StatusCode: resp.StatusCode, Message: string(bodyBytes)}
+	}
 	return resp.StatusCode, nil
 }
 
-func SendFidelityRequest(ft bool, userID string, bonus int) (int, error) {
-	statusCode, err := trySendFidelityRequest(userID, bonus)
+func SendFidelityRequest(ctx context.Context, ft bool, request FidelityRequest) (int, error) {
+	var statusCode int
+	var err error
+	if ft && fidelityHealth.Paused() {
+		// Fidelity fora do ar: nem tenta, o bônus vai direto para a fila
+		err = errors.New("entregas de bônus pausadas, Fidelity fora do ar")
+	} else if ft {
+		statusCode, err = callWithBreaker(ctx, breakers.Fidelity, func() (int, error) {
+			return retry(ctx, retryPolicies.Fidelity, func() (int, error) {
+				return trySendFidelityRequest(ctx, request)
+			})
+		})
+	} else {
+		statusCode, err = trySendFidelityRequest(ctx, request)
+	}
 
 	if ft {
+		if err != nil && isFidelityUnreachable(err) {
+			fidelityHealth.ReportFailure(err)
+		}
 		if err != nil {
-			log.Printf("[pendingBonusQueue] Adicionando bonus do usuario '%s' na fila para ser processado em outro momento", userID)
-			pendingBonusQueue.ch <- FidelityRequest{User: userID, Bonus: bonus}
+			log.Printf("[pendingBonusQueue] Adicionando bonus do usuario '%s' na fila para ser processado em outro momento", request.User)
+			if err := pendingBonusQueue.Enqueue(request); err != nil {
+				log.Printf("[pendingBonusQueue] ERRO: %v", err)
+			}
 			return 0, err
 		}
 	}
@@ -427,27 +615,42 @@ func buyTicketHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Requisição para /buyTicket recebida: %+v", body)
 
+	if body.Points < 0 {
+		writeError(w, newAPIError(http.StatusBadRequest, fmt.Errorf("points não pode ser negativo")))
+		return
+	}
+
 	if err := parseDate(body.Day); err != nil {
 		log.Printf("ERRO: Data em formato inválido: %s", body.Day)
 		apiErr := newAPIError(http.StatusBadRequest, fmt.Errorf("data em formato inválido: %s", body.Day))
 		writeError(w, apiErr)
 		return
 	}
+	ctx, cancel := newBuyTicketContext(r, ft)
+	defer cancel()
+
 	log.Println("Buscando informações de voo em AirlinesHub...")
 
 	var flightData *FlightData
 	if ft {
-		// ---- RETRY + TIMEOUT AQUI ----
-		flightData, err = retry[*FlightData](3, func() (*FlightData, error) {
-			return GetFlight(ft, body.Flight, body.Day)
+		key := cacheKey(body.Flight, body.Day)
+		cached, ok := flightCache.GetOrRevalidate(key, func(ctx context.Context) error {
+			_, err := fetchFlight(ctx, body.Flight, body.Day)
+			return err
 		})
+		if ok {
+			log.Printf("Usando valor recente do cache para o voo: %+v", cached)
+			flightData = cached
+		} else {
+			// ---- CIRCUIT BREAKER + RETRY + TIMEOUT AQUI ----
+			flightData, err = fetchFlight(ctx, body.Flight, body.Day)
+		}
 		if err != nil {
-			key := cacheKey(body.Flight, body.Day)
-			cached, ok := flightCache[key]
+			stale, age, ok := flightCache.GetStale(key)
 
 			if ok {
-				log.Printf("AVISO: Falha ao buscar voo online, usando valor do cache: %+v", cached)
-				flightData = cached
+				log.Printf("AVISO: Falha ao buscar voo online, usando valor do cache (idade %v): %+v", age.Round(time.Second), stale)
+				flightData = stale
 			} else {
 				log.Printf("ERRO: falha ao buscar dados do voo após retries e sem cache: %v", err)
 				apiErr := newAPIError(http.StatusInternalServerError, fmt.Errorf("erro ao buscar dados do voo: %w", err))
@@ -456,7 +659,7 @@ func buyTicketHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	} else {
-		flightData, err = GetFlight(ft, body.Flight, body.Day)
+		flightData, err = GetFlight(ctx, ft, body.Flight, body.Day)
 		if err != nil {
 			log.Printf("ERRO: falha ao buscar dados do voo: %v", err)
 			apiErr := newAPIError(http.StatusInternalServerError, fmt.Errorf("erro na tentativa de buscar dados do voo: %w", err))
@@ -467,22 +670,84 @@ func buyTicketHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Voo buscado com sucesso. Dados de voo: %+v", flightData)
 
+	if abortIfDone(ctx, w, "reserva do assento") {
+		return
+	}
+
+	// sem Idempotency-Key do cliente, a reserva e a venda no AirlinesHub são identificadas pelo próprio ticket
+	ticketID := uuid.New()
+	idempotencyKey := ticketID.String()
+	if key := r.Header.Get(IdempotencyKeyHeader); key != "" {
+		idempotencyKey = scopedIdempotencyKey(body.User, key)
+	}
+
+	// o assento fica reservado (e o preço travado) enquanto a compra é montada; a venda só
+	// acontece quando a saga confirma o hold
+	hold, err := HoldSeat(ctx, ft, body.Flight, body.Day, idempotencyKey)
+	if err != nil {
+		if errors.Is(err, ErrFlightSoldOut) {
+			log.Printf("ERRO: voo %s esgotado no dia %s: %v", body.Flight, body.Day, err)
+			writeError(w, newAPIError(http.StatusConflict, fmt.Errorf("não há mais assentos disponíveis no voo %s do dia %s", body.Flight, body.Day)))
+			return
+		}
+		if errors.Is(err, ErrHoldConfirmed) {
+			log.Printf("ERRO: reserva do Idempotency-Key já confirmada no AirlinesHub: %v", err)
+			writeError(w, newAPIError(http.StatusConflict, errors.New("a compra com este Idempotency-Key já foi concluída")))
+			return
+		}
+		if errors.Is(err, ErrCircuitOpen) {
+			log.Printf("[ERRO] (Circuit Breaker) %v", err)
+			writeError(w, newAPIError(http.StatusServiceUnavailable, fmt.Errorf("falha ao reservar assento: %w", err)))
+			return
+		}
+		log.Printf("ERRO: falha ao reservar assento: %v", err)
+		writeError(w, newAPIError(http.StatusInternalServerError, fmt.Errorf("falha ao reservar assento: %w", err)))
+		return
+	}
+	log.Printf("Assento reservado no AirlinesHub (hold %s) até %s por %.2f", hold.ID, hold.ExpiresAt.Format(time.RFC3339), hold.Value)
+
+	// até a saga começar, é ela quem cuida do hold; antes disso, uma falha libera o assento
+	sagaStarted := false
+	defer func() {
+		if !sagaStarted {
+			go releaseAbandonedHold(hold.ID)
+		}
+	}()
+
+	if abortIfDone(ctx, w, "cotação do dólar") {
+		return
+	}
+
 	log.Println("Buscando cotação do dolar em Exchange...")
-	dolarExchangeRate, err := getDolarValueInReal(ft)
+	var dolarExchangeRate float64
+	rateStrategy := "live"
+	var rateAge time.Duration
+	if ft {
+		dolarExchangeRate, err = callWithBreaker(ctx, breakers.Exchange, func() (float64, error) {
+			return retry(ctx, retryPolicies.Exchange, func() (float64, error) {
+				return getDolarValueInReal(ctx, ft)
+			})
+		})
+	} else {
+		dolarExchangeRate, err = getDolarValueInReal(ctx, ft)
+	}
 	if err != nil {
 		if ft {
 			log.Printf("AVISO: falha ao buscar cotação do dólar: %v", err)
 
-			// tenta usar média das últimas 10 taxas
-			media := avg(dolarCache)
+			// tenta estimar a cotação a partir das cotações recentes
+			estimate, estimateErr := dolarRates.Estimate()
 
-			if media > 0 {
-				log.Printf("Usando média das últimas %d cotações: %.2f", len(dolarCache), media)
-				dolarExchangeRate = media
+			if estimateErr == nil {
+				log.Printf("Usando cotação estimada (%s) a partir de %d cotações, a mais recente de %v atrás: %.2f",
+					estimate.Strategy, estimate.Samples, estimate.Age.Round(time.Millisecond), estimate.Value)
+				dolarExchangeRate = estimate.Value
+				rateStrategy = estimate.Strategy
+				rateAge = estimate.Age
 			} else {
-				// sem cache -> erro real
-				log.Printf("ERRO: nenhum valor de cache disponível para cotação do dólar")
-				apiErr := newAPIError(http.StatusInternalServerError, fmt.Errorf("falha ao buscar cotação do dólar e cache vazio"))
+				// sem cotações recentes -> erro real
+				log.Printf("ERRO: não foi possível estimar a cotação do dólar: %v", estimateErr)
+				apiErr := newAPIError(http.StatusInternalServerError, fmt.Errorf("falha ao buscar cotação do dólar: %w", estimateErr))
 				writeError(w, apiErr)
 				return
 			}
@@ -495,29 +760,108 @@ func buyTicketHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	price := dolarExchangeRate * flightData.Value
+	// o preço é o travado pelo hold, que pode diferir do consultado no /flight (ou no cache)
+	if hold.Value != flightData.Value {
+		log.Printf("Preço do voo mudou de %.2f para %.2f na reserva", flightData.Value, hold.Value)
+	}
+	price := dolarExchangeRate * hold.Value
 
 	price, _ = strconv.ParseFloat(fmt.Sprintf("%.2f", price), 64)
 
 	log.Printf("Valor convertido para real com sucesso: %.2f", price)
 
+	pointsDiscount, _ := strconv.ParseFloat(fmt.Sprintf("%.2f", float64(body.Points)*cfg.PointValue), 64)
+	if pointsDiscount > price {
+		apiErr := newAPIError(http.StatusBadRequest, fmt.Errorf("%d pontos valem R$ %.2f, mais que o preço da passagem (R$ %.2f)", body.Points, pointsDiscount, price))
+		writeError(w, apiErr)
+		return
+	}
+	cashAmount, _ := strconv.ParseFloat(fmt.Sprintf("%.2f", price-pointsDiscount), 64)
+
+	bonus := bonusRules.Compute(body.Flight, hold.Value, lookupTier(ctx, ft, body.User), time.Now())
+	log.Printf("Bônus do usuário %s: %s", body.User, bonus)
+
 	ticket := Ticket{
-		FlightNumber: body.Flight,
-		FlightDay:    body.Day,
-		Price:        price,
-		UserID:       body.User,
-		Status:       "PENDING_PAYMENT",
+		ID:             ticketID,
+		FlightNumber:   body.Flight,
+		FlightDay:      body.Day,
+		HoldID:         hold.ID,
+		Price:          price,
+		CashAmount:     cashAmount,
+		Points:         body.Points,
+		PointsDiscount: pointsDiscount,
+		UserID:         body.User,
+		Ft:             ft,
+		Bonus:          bonus.Points,
+		Status:         "PENDING_PAYMENT",
+		ExchangeRate:   dolarExchangeRate,
+		RateStrategy:   rateStrategy,
+		IdempotencyKey: idempotencyKey,
+		CreatedAt:      time.Now(),
+	}
+	if rateAge > 0 {
+		ticket.RateAge = rateAge.Round(time.Millisecond).String()
 	}
 	log.Printf("Ticket criado com sucesso %+v", ticket)
 
-	transactionID, err := RequestTicketSell(ft, ticket.FlightNumber, ticket.FlightDay)
+	if abortIfDone(ctx, w, "venda da passagem") {
+		return
+	}
+
+	if err := saveTicket(&ticket); err != nil {
+		apiErr := newAPIError(http.StatusInternalServerError, fmt.Errorf("falha ao registrar ticket: %w", err))
+		writeError(w, apiErr)
+		return
+	}
+
+	saga, err := sagas.Begin(ticket)
+	if err != nil {
+		// sem saga nada recuperaria o ticket: ele é encerrado aqui e o hold é liberado pelo defer acima
+		ticket.Status = "FAILED"
+		ticket.FailureReason = fmt.Sprintf("falha ao registrar compra: %v", err)
+		if saveErr := saveTicket(&ticket); saveErr != nil {
+			log.Printf("ERRO: falha ao marcar ticket %s como FAILED: %v", ticket.ID, saveErr)
+		}
+		apiErr := newAPIError(http.StatusInternalServerError, fmt.Errorf("falha ao registrar compra: %w", err))
+		writeError(w, apiErr)
+		return
+	}
+	sagaStarted = true
+
+	// reserva de pontos -> confirmação do hold (venda) -> débito dos pontos -> confirmação do ticket -> bônus,
+	// com compensação se algum passo falhar
+	err = sagas.Run(ctx, saga)
 	if err != nil {
+		var statusErr *HTTPStatusError
+		if errors.As(err, &statusErr) && statusErr.Service == "Fidelity" && statusErr.StatusCode == http.StatusUnprocessableEntity {
+			log.Printf("ERRO: pontos insuficientes para o resgate: %v", err)
+			apiErr := newAPIError(http.StatusUnprocessableEntity, fmt.Errorf("falha ao resgatar pontos: %s", statusErr.Message))
+			writeError(w, apiErr)
+			return
+		}
+		if errors.Is(err, ErrFlightSoldOut) {
+			log.Printf("ERRO: voo %s esgotado no dia %s: %v", body.Flight, body.Day, err)
+			apiErr := newAPIError(http.StatusConflict, fmt.Errorf("não há mais assentos disponíveis no voo %s do dia %s", body.Flight, body.Day))
+			writeError(w, apiErr)
+			return
+		}
+		if errors.Is(err, ErrHoldExpired) {
+			log.Printf("ERRO: reserva %s expirou antes da confirmação: %v", hold.ID, err)
+			apiErr := newAPIError(http.StatusConflict, fmt.Errorf("a reserva do assento expirou antes da confirmação, tente novamente"))
+			writeError(w, apiErr)
+			return
+		}
 		if ft {
+			if errors.Is(err, ErrCircuitOpen) {
+				log.Printf("[ERRO] (Circuit Breaker) %v", err)
+				apiErr := newAPIError(http.StatusServiceUnavailable, fmt.Errorf("falha ao realizar venda de ticket: %w", err))
+				writeError(w, apiErr)
+				return
+			}
 			if errors.Is(err, ErrTicketSellTimeout) {
-				log.Printf("[ERRO] (Falha Graciosa) " + err.Error())
-				ticket.Status = "FAILED"
-				log.Printf("[ERRO] Pagamento da passagem aérea não será processado!")
-				apiErr := newAPIError(http.StatusGatewayTimeout, fmt.Errorf("falha ao realizar venda de ticket: %w", err))
+				log.Printf("[ERRO] (Falha Graciosa) %v", err)
+				log.Printf("[ERRO] Venda incerta: a compra %s será conferida com o AirlinesHub em segundo plano", ticket.ID)
+				apiErr := newAPIError(http.StatusGatewayTimeout, fmt.Errorf("falha ao realizar venda de ticket: %w A venda será conferida com o AirlinesHub e o ticket %s atualizado.", err, ticket.ID))
 				writeError(w, apiErr)
 				return
 			}
@@ -528,21 +872,7 @@ func buyTicketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ticket.TransactionID = uuid.NullUUID{UUID: transactionID, Valid: true}
-	ticket.Status = "PAID"
-	ticketDB[transactionID] = ticket
-	log.Printf("Ticket armazenado no 'banco de dados' local: %+v", ticket)
-
-	bonus := int(math.Round(flightData.Value))
-
-	log.Printf("Enviando bônus de %d (baseado no valor US$ %.2f) para usuário %s", bonus, flightData.Value, body.User)
-
-	_, err = SendFidelityRequest(ft, body.User, bonus)
-	if err != nil {
-		log.Printf("AVISO: Falha ao enviar bônus da venda %s: %v", transactionID.String(), err)
-	} else {
-		log.Printf("Sucesso: Bônus enviado para usuário %s", body.User)
-	}
+	transactionID := saga.Ticket.TransactionID.UUID
 
 	response := BuyTicketResponse{
 		TransactionID: transactionID.String(),
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsousabt/shared/journal"
+	"github.com/google/uuid"
+)
+
+const (
+	ReconcilePaid          = "PAID"           // a venda aconteceu e a compra foi concluída (ticket PAID e bônus)
+	ReconcileCancelled     = "CANCELLED"      // a venda aconteceu, mas a compra não foi concluída: a venda órfã foi cancelada
+	ReconcileSaleCancelled = "SALE_CANCELLED" // a venda aconteceu, mas já estava cancelada no AirlinesHub: a compra foi desfeita
+	ReconcileNoSale        = "NO_SALE"        // a venda não aconteceu: a compra foi desfeita
+	ReconcileManualReview  = "MANUAL_REVIEW"  // o AirlinesHub não respondeu às consultas: a compra aguarda análise manual
+)
+
+const saleNotFound = "NOT_FOUND"
+
+// Registro de auditoria de uma decisão do Reconciler sobre uma compra com a venda incerta.
+// SaleStatus é a situação da venda (ou do hold, se a venda não existe) encontrada no AirlinesHub.
+type Reconciliation struct {
+	TicketID       uuid.UUID `json:"ticketID"`
+	User           string    `json:"user"`
+	Flight         string    `json:"flight"`
+	Day            string    `json:"day"`
+	HoldID         string    `json:"holdID,omitempty"`
+	IdempotencyKey string    `json:"idempotencyKey"`
+	TransactionID  string    `json:"transactionID,omitempty"`
+	SaleStatus     string    `json:"saleStatus"`
+	Decision       string    `json:"decision"`
+	Reason         string    `json:"reason"`
+	Attempts       int       `json:"attempts"` // consultas ao AirlinesHub até a decisão
+	UncertainSince time.Time `json:"uncertainSince"`
+	DecidedAt      time.Time `json:"decidedAt"`
+}
+
+// Confere com o AirlinesHub as compras cuja venda ficou incerta (timeout na venda ou reinício no meio dela).
+// Se a venda aconteceu e a compra ainda é recente, a saga é retomada (débito dos pontos, ticket PAID e bônus);
+// se é antiga demais, a venda órfã é cancelada. Se a venda não aconteceu, a compra é desfeita.
+// Cada decisão é gravada em um registro de auditoria, que guarda todas as decisões de cada compra.
+// Depois de config.MaxAttempts consultas sem resposta a compra sai da reconciliação e aguarda análise manual.
+type Reconciler struct {
+	mu sync.Mutex
+
+	config        ReconcileConfig
+	sagas         *SagaCoordinator
+	records       map[uuid.UUID][]Reconciliation // decisões de cada ticket, na ordem em que foram tomadas
+	attempts      map[uuid.UUID]int
+	journal       *journal.Journal
+	snapshotEvery int
+	done          chan struct{}
+}
+
+func NewReconciler(c ReconcileConfig, sagas *SagaCoordinator) (*Reconciler, error) {
+	journal, err := journal.Open(c.Dir, "reconciliations", c.Journal)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reconciler{
+		config:        c,
+		sagas:         sagas,
+		records:       make(map[uuid.UUID][]Reconciliation),
+		attempts:      make(map[uuid.UUID]int),
+		journal:       journal,
+		snapshotEvery: max(c.SnapshotEvery, 1),
+		done:          make(chan struct{}),
+	}
+
+	err = journal.Load(func(data []byte) error {
+		var record Reconciliation
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		r.records[record.TicketID] = append(r.records[record.TicketID], record)
+		return nil
+	})
+	if err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("falha ao recuperar auditoria de reconciliações: %w", err)
+	}
+
+	return r, nil
+}
+
+func (r *Reconciler) Start() {
+	go r.loop()
+}
+
+func (r *Reconciler) loop() {
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.ReconcilePending(time.Now())
+		}
+	}
+}
+
+// ReconcilePending confere as compras incertas há pelo menos config.Delay
+func (r *Reconciler) ReconcilePending(now time.Time) {
+	for _, saga := range r.sagas.Uncertain() {
+		if now.Sub(saga.UpdatedAt) < r.config.Delay {
+			continue
+		}
+		r.reconcile(saga)
+	}
+}
+
+// Situação da venda da compra no AirlinesHub. Com hold, a venda é a da confirmação dele;
+// sem hold, é a venda feita com o Idempotency-Key da compra.
+func checkSale(ctx context.Context, s PurchaseSaga) (transactionID uuid.NullUUID, status string, err error) {
+	var id uuid.UUID
+	if s.Ticket.HoldID != "" {
+		hold, found, err := GetHold(ctx, s.Ticket.HoldID)
+		if err != nil {
+			return uuid.NullUUID{}, "", err
+		}
+		if !found {
+			return uuid.NullUUID{}, saleNotFound, nil
+		}
+		if hold.TransactionID == "" {
+			return uuid.NullUUID{}, hold.Status, nil
+		}
+		if id, err = uuid.Parse(hold.TransactionID); err != nil {
+			return uuid.NullUUID{}, "", fmt.Errorf("hold %s com transactionID inválido: %w", hold.ID, err)
+		}
+	} else {
+		var found bool
+		id, found, err = FindSaleByIdempotencyKey(ctx, s.Ticket.IdempotencyKey)
+		if err != nil {
+			return uuid.NullUUID{}, "", err
+		}
+		if !found {
+			return uuid.NullUUID{}, saleNotFound, nil
+		}
+	}
+
+	sale, found, err := GetSale(ctx, id)
+	if err != nil {
+		return uuid.NullUUID{}, "", err
+	}
+	if !found {
+		return uuid.NullUUID{}, saleNotFound, nil
+	}
+	return uuid.NullUUID{UUID: id, Valid: true}, sale.Status, nil
+}
+
+func (r *Reconciler) reconcile(saga PurchaseSaga) {
+	if !r.sagas.acquire(saga.ID) {
+		return
+	}
+
+	ctx, cancel := newBackgroundContext()
+	defer cancel()
+
+	r.mu.Lock()
+	r.attempts[saga.ID]++
+	attempts := r.attempts[saga.ID]
+	r.mu.Unlock()
+
+	transactionID, status, err := checkSale(ctx, saga)
+	if err != nil && attempts < max(r.config.MaxAttempts, 1) {
+		r.sagas.release(saga.ID)
+		log.Printf("[Reconciler] (%s) Falha ao consultar a venda no AirlinesHub (tentativa %d), nova tentativa em %v: %v",
+			saga.ID, attempts, r.config.Interval, err)
+		return
+	}
+
+	record := Reconciliation{
+		TicketID:       saga.ID,
+		User:           saga.Ticket.UserID,
+		Flight:         saga.Ticket.FlightNumber,
+		Day:            saga.Ticket.FlightDay,
+		HoldID:         saga.Ticket.HoldID,
+		IdempotencyKey: saga.Ticket.IdempotencyKey,
+		SaleStatus:     status,
+		Attempts:       attempts,
+		UncertainSince: saga.UpdatedAt,
+	}
+	if transactionID.Valid {
+		record.TransactionID = transactionID.UUID.String()
+	}
+
+	if err != nil {
+		r.holdForReview(saga, record, err)
+		return
+	}
+
+	age := time.Since(saga.Ticket.CreatedAt)
+	switch {
+	case status == "SOLD" && age <= r.config.CompleteWindow:
+		record.Decision = ReconcilePaid
+		record.Reason = "venda concluída no AirlinesHub após o timeout"
+	case status == "SOLD":
+		record.Decision = ReconcileCancelled
+		record.Reason = fmt.Sprintf("venda encontrada %v após a compra, acima da janela de %v para concluí-la",
+			age.Round(time.Second), r.config.CompleteWindow)
+	case status == "CANCELLED":
+		record.Decision = ReconcileSaleCancelled
+		record.Reason = "venda encontrada já cancelada no AirlinesHub"
+	default:
+		record.Decision = ReconcileNoSale
+		record.Reason = fmt.Sprintf("venda não realizada no AirlinesHub (%s)", status)
+	}
+	r.record(record)
+
+	if record.Decision == ReconcilePaid {
+		// a saga volta a RUNNING com a venda concluída e segue a partir do débito dos pontos
+		paid := saga
+		paid.Ticket.TransactionID = transactionID
+		paid.Ticket.Status = "PENDING_PAYMENT"
+		paid.Ticket.FailureReason = ""
+		paid.State = SagaRunning
+		paid.StepDone = true
+		paid.Error = ""
+		err := r.sagas.persist(&paid)
+		if err == nil {
+			saveTicket(&paid.Ticket)
+			r.sagas.release(paid.ID)
+
+			log.Printf("[Reconciler] (%s) Venda %s encontrada no AirlinesHub, concluindo a compra", paid.ID, transactionID.UUID)
+			if err := r.sagas.Run(ctx, &paid); err != nil {
+				record.Decision = ReconcileCancelled
+				record.Reason = fmt.Sprintf("falha ao concluir a compra após a venda: %v", err)
+				record.DecidedAt = time.Now()
+				r.record(record)
+				log.Printf("[Reconciler] (%s) Falha ao concluir a compra, a venda será cancelada: %v", paid.ID, err)
+			}
+			return
+		}
+
+		// sem o registro da decisão um reinício não retomaria a compra: a venda é desfeita
+		record.Decision = ReconcileCancelled
+		record.Reason = fmt.Sprintf("falha ao gravar a conclusão da compra no saga log: %v", err)
+		record.DecidedAt = time.Now()
+		r.record(record)
+		log.Printf("[Reconciler] (%s) Falha ao gravar a conclusão da compra, a venda será cancelada: %v", saga.ID, err)
+	}
+
+	// sem a venda (ou com ela tarde demais) a compra é desfeita: o hold é liberado, cancelando a venda
+	// se ela existir, e os pontos reservados são devolvidos
+	saga.Ticket.Status = "FAILED"
+	saga.Ticket.FailureReason = record.Reason
+	saveTicket(&saga.Ticket)
+	saga.State = SagaCompensating
+	saga.Error = record.Reason
+	// mesmo sem o registro a compensação segue: a saga continua incerta no saga log, então na próxima rodada
+	// (ou após um reinício) a venda cancelada é encontrada e a compensação (idempotente) é repetida
+	r.sagas.checkpoint(&saga)
+	r.sagas.release(saga.ID)
+
+	log.Printf("[Reconciler] (%s) Decisão %s: %s", saga.ID, record.Decision, record.Reason)
+	r.sagas.compensate(saga)
+}
+
+// Tira da reconciliação uma compra cujo AirlinesHub não respondeu em config.MaxAttempts consultas:
+// a saga e o ticket ficam MANUAL_REVIEW, sem concluir nem desfazer a compra
+func (r *Reconciler) holdForReview(saga PurchaseSaga, record Reconciliation, err error) {
+	record.Decision = ReconcileManualReview
+	record.Reason = fmt.Sprintf("AirlinesHub não respondeu em %d consultas: %v", record.Attempts, err)
+	r.record(record)
+
+	saga.Ticket.Status = "MANUAL_REVIEW"
+	saga.Ticket.FailureReason = record.Reason
+	saveTicket(&saga.Ticket)
+	saga.State = SagaManualReview
+	saga.Error = record.Reason
+	r.sagas.checkpoint(&saga)
+	r.sagas.release(saga.ID)
+
+	log.Printf("[Reconciler] (%s) Decisão %s: %s", saga.ID, record.Decision, record.Reason)
+}
+
+// Grava a decisão no registro de auditoria
+func (r *Reconciler) record(record Reconciliation) {
+	if record.DecidedAt.IsZero() {
+		record.DecidedAt = time.Now()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.attempts, record.TicketID)
+	r.records[record.TicketID] = append(r.records[record.TicketID], record)
+	if err := r.journal.Append(record); err != nil {
+		log.Printf("[Reconciler] ERRO: falha ao gravar auditoria da compra %s: %v", record.TicketID, err)
+		return
+	}
+
+	if r.journal.Records() >= r.snapshotEvery {
+		var records []any
+		for _, history := range r.records {
+			for _, record := range history {
+				records = append(records, record)
+			}
+		}
+		if err := r.journal.Snapshot(records); err != nil {
+			log.Printf("[Reconciler] ERRO: falha ao gravar snapshot da auditoria: %v", err)
+		}
+	}
+}
+
+// History retorna as decisões da compra, da mais antiga para a mais recente
+func (r *Reconciler) History(ticketID uuid.UUID) []Reconciliation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.records[ticketID])
+}
+
+// List retorna uma página das decisões com a decisão informada (todas, se vazia), da mais recente para a mais antiga
+func (r *Reconciler) List(decision string, limit, offset int) (page []Reconciliation, total int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var records []Reconciliation
+	for _, history := range r.records {
+		for _, record := range history {
+			if decision == "" || record.Decision == decision {
+				records = append(records, record)
+			}
+		}
+	}
+	slices.SortFunc(records, func(a, b Reconciliation) int {
+		return cmp.Or(b.DecidedAt.Compare(a.DecidedAt), strings.Compare(a.TicketID.String(), b.TicketID.String()))
+	})
+
+	total = len(records)
+	page = append([]Reconciliation{}, paginate(records, limit, offset)...)
+	return page, total
+}
+
+func (r *Reconciler) Close() error {
+	close(r.done)
+	return r.journal.Close()
+}
+
+var reconciler *Reconciler
+
+type ReconciliationListResponse struct {
+	Reconciliations []Reconciliation `json:"reconciliations"`
+	Pending         int              `json:"pending"` // compras com a venda incerta aguardando decisão
+	Total           int              `json:"total"`
+	Limit           int              `json:"limit"`
+	Offset          int              `json:"offset"`
+}
+
+// GET /admin/reconciliations?decision=PAID&limit=20&offset=0
+func listReconciliationsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, err := parseQueryInt(query.Get("limit"), defaultPageLimit)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		writeError(w, newAPIError(http.StatusBadRequest, fmt.Errorf("limit deve ser um número entre 1 e %d", maxPageLimit)))
+		return
+	}
+	offset, err := parseQueryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		writeError(w, newAPIError(http.StatusBadRequest, fmt.Errorf("offset deve ser um número maior ou igual a 0")))
+		return
+	}
+	decision := strings.ToUpper(query.Get("decision"))
+	switch decision {
+	case "", ReconcilePaid, ReconcileCancelled, ReconcileSaleCancelled, ReconcileNoSale, ReconcileManualReview:
+	default:
+		writeError(w, newAPIError(http.StatusBadRequest, fmt.Errorf("decision deve ser %s, %s, %s, %s ou %s",
+			ReconcilePaid, ReconcileCancelled, ReconcileSaleCancelled, ReconcileNoSale, ReconcileManualReview)))
+		return
+	}
+
+	records, total := reconciler.List(decision, limit, offset)
+	writeJSON(w, http.StatusOK, ReconciliationListResponse{
+		Reconciliations: records,
+		Pending:         len(sagas.Uncertain()),
+		Total:           total,
+		Limit:           limit,
+		Offset:          offset,
+	})
+}
+
+type ReconciliationHistory struct {
+	TicketID  uuid.UUID        `json:"ticketID"`
+	Decisions []Reconciliation `json:"decisions"` // da mais antiga para a mais recente
+}
+
+// GET /admin/reconciliations/{ticketID}
+func getReconciliationHandler(w http.ResponseWriter, r *http.Request) {
+	ticketID, err := uuid.Parse(r.PathValue("ticketID"))
+	if err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, fmt.Errorf("ticketID inválido: %w", err)))
+		return
+	}
+	decisions := reconciler.History(ticketID)
+	if len(decisions) == 0 {
+		writeError(w, newAPIError(http.StatusNotFound, fmt.Errorf("nenhuma reconciliação para o ticket %s", ticketID)))
+		return
+	}
+	writeJSON(w, http.StatusOK, ReconciliationHistory{TicketID: ticketID, Decisions: decisions})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// Reserva de pontos no Fidelity, identificada pelo id do ticket
+type Redemption struct {
+	ID            string `json:"id"`
+	User          string `json:"user"`
+	Points        int    `json:"points"`
+	Status        string `json:"status"`
+	TransactionID string `json:"transactionID,omitempty"`
+}
+
+type ReservePointsRequest struct {
+	ReservationID string `json:"reservationID"`
+	User          string `json:"user"`
+	Points        int    `json:"points"`
+}
+
+type RedeemPointsRequest struct {
+	TransactionID string `json:"transactionID"`
+}
+
+// Faz uma chamada à API de resgate do Fidelity. Todas as operações são idempotentes pelo id da reserva,
+// então com tolerância a falhas ligada podem ser repetidas com segurança.
+func callRedemptionAPI(ctx context.Context, ft bool, method, path string, body any) (Redemption, error) {
+	call := func() (Redemption, error) {
+		return tryCallRedemptionAPI(ctx, ft, method, path, body)
+	}
+	if !ft {
+		return call()
+	}
+	return callWithBreaker(ctx, breakers.Fidelity, func() (Redemption, error) {
+		return retry(ctx, retryPolicies.Fidelity, call)
+	})
+}
+
+func tryCallRedemptionAPI(ctx context.Context, ft bool, method, path string, body any) (Redemption, error) {
+	endpoint := cfg.URL.Fidelity + path
+
+	var reqBody io.Reader
+	if body != nil {
+		reqData, err := json.Marshal(body)
+		if err != nil {
+			return Redemption{}, fmt.Errorf("falha ao serializar request body: %w", err)
+		}
+		reqBody = bytes.NewBuffer(reqData)
+	}
+
+	req, err := newRequest(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return Redemption{}, fmt.Errorf("falha ao montar requisição %s para %s: %w", method, endpoint, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var resp *http.Response
+	if ft {
+		resp, err = ftHttpClient.Do(req)
+	} else {
+		resp, err = client.Do(req)
+	}
+	if err != nil {
+		log.Printf("ERRO: falha ao enviar requisição %s para fidelity (%s): %v", method, endpoint, err)
+		return Redemption{}, fmt.Errorf("falha ao enviar requisição %s para %s: %w", method, endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return Redemption{}, &HTTPStatusError{Service: "Fidelity", StatusCode: resp.StatusCode, Message: string(bytes.TrimSpace(bodyBytes))}
+	}
+
+	var redemption Redemption
+	if err := json.NewDecoder(resp.Body).Decode(&redemption); err != nil {
+		return Redemption{}, fmt.Errorf("falha ao decodificar resposta do Fidelity: %w", err)
+	}
+	return redemption, nil
+}
+
+// Reserva os pontos do ticket no Fidelity (422 se o saldo disponível não for suficiente)
+func ReservePoints(ctx context.Context, ft bool, ticket Ticket) error {
+	log.Printf("Reservando %d pontos do usuário %s (ticket %s)", ticket.Points, ticket.UserID, ticket.ID)
+
+	redemption, err := callRedemptionAPI(ctx, ft, "POST", "/redemptions", ReservePointsRequest{
+		ReservationID: ticket.ID.String(),
+		User:          ticket.UserID,
+		Points:        ticket.Points,
+	})
+	if err != nil {
+		return err
+	}
+	if redemption.Status != "RESERVED" && redemption.Status != "REDEEMED" {
+		return fmt.Errorf("reserva de pontos %s está %s", redemption.ID, redemption.Status)
+	}
+	return nil
+}
+
+// Confirma a reserva, debitando os pontos do saldo do usuário
+func RedeemPoints(ctx context.Context, ft bool, ticket Ticket) error {
+	log.Printf("Debitando %d pontos do usuário %s (ticket %s)", ticket.Points, ticket.UserID, ticket.ID)
+
+	_, err := callRedemptionAPI(ctx, ft, "POST", "/redemptions/"+ticket.ID.String()+"/confirm", RedeemPointsRequest{
+		TransactionID: ticket.TransactionID.UUID.String(),
+	})
+	return err
+}
+
+// Libera a reserva (devolvendo os pontos, se já tinham sido debitados). Reserva inexistente não é erro.
+func ReleasePoints(ctx context.Context, ticket Ticket) error {
+	log.Printf("Liberando reserva de %d pontos do usuário %s (ticket %s)", ticket.Points, ticket.UserID, ticket.ID)
+
+	_, err := callRedemptionAPI(ctx, true, "POST", "/redemptions/"+ticket.ID.String()+"/release", nil)
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		log.Printf("AVISO: reserva de pontos do ticket %s não existe no Fidelity, nada a liberar", ticket.ID)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+type JitterStrategy string
+
+const (
+	JitterNone         JitterStrategy = "none"
+	JitterFull         JitterStrategy = "full"
+	JitterEqual        JitterStrategy = "equal"
+	JitterDecorrelated JitterStrategy = "decorrelated"
+)
+
+// Erro de resposta HTTP não-OK de um serviço externo
+type HTTPStatusError struct {
+	Service    string
+	StatusCode int
+	Message    string
+}
+
+func (e *HTTPStatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("serviço %s retornou status %d: %s", e.Service, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("serviço %s retornou status não-OK %d", e.Service, e.StatusCode)
+}
+
+// Resposta 4xx do serviço, exceto 408 e 429: a requisição foi recusada, mas o serviço está no ar
+func isClientError(err error) bool {
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	code := statusErr.StatusCode
+	return code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
+
+// Classificação padrão de erros:
+// - circuito aberto, prazo da requisição esgotado, erros de sintaxe/tipo no JSON e status 4xx (exceto 408 e 429) não são retentáveis
+// - resposta vazia (falha por omissão), erros de rede e status 5xx são retentáveis
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return false
+	}
+
+	return !isClientError(err)
+}
+
+// Só é seguro repetir uma operação não idempotente (ex: venda) se a requisição
+// com certeza não chegou ao servidor, ou seja, falhou ao abrir a conexão.
+func isConnectionError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+type RetryEvent struct {
+	Policy  string
+	Attempt int
+	Err     error
+	Delay   time.Duration
+	Elapsed time.Duration
+	Reason  string
+}
+
+type RetryHooks struct {
+	OnRetry   func(RetryEvent) // falha retentável, antes de aguardar Delay
+	OnGiveUp  func(RetryEvent) // erro não retentável, tentativas esgotadas ou tempo total excedido
+	OnSuccess func(RetryEvent)
+}
+
+type RetryMetrics struct {
+	Calls     atomic.Int64
+	Attempts  atomic.Int64
+	Retries   atomic.Int64
+	Successes atomic.Int64
+	GiveUps   atomic.Int64
+}
+
+type RetryPolicy struct {
+	Name        string
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	MaxElapsed  time.Duration // 0 = sem limite de tempo total
+	Jitter      JitterStrategy
+	Retryable   func(error) bool
+	Hooks       []RetryHooks
+	Metrics     *RetryMetrics
+}
+
+type RetryPolicyStatus struct {
+	Name        string `json:"name"`
+	MaxAttempts int    `json:"maxAttempts"`
+	BaseDelay   string `json:"baseDelay"`
+	MaxDelay    string `json:"maxDelay"`
+	MaxElapsed  string `json:"maxElapsed"`
+	Jitter      string `json:"jitter"`
+	Calls       int64  `json:"calls"`
+	Attempts    int64  `json:"attempts"`
+	Retries     int64  `json:"retries"`
+	Successes   int64  `json:"successes"`
+	GiveUps     int64  `json:"giveUps"`
+}
+
+func NewRetryPolicy(name string, c RetryConfig, retryable func(error) bool) *RetryPolicy {
+	p := &RetryPolicy{
+		Name:        name,
+		MaxAttempts: max(c.MaxAttempts, 1),
+		BaseDelay:   c.BaseDelay,
+		MaxDelay:    c.MaxDelay,
+		MaxElapsed:  c.MaxElapsed,
+		Jitter:      c.Jitter,
+		Retryable:   retryable,
+		Metrics:     &RetryMetrics{},
+	}
+	p.Hooks = []RetryHooks{logRetryHooks(), metricsRetryHooks(p.Metrics)}
+	return p
+}
+
+func logRetryHooks() RetryHooks {
+	return RetryHooks{
+		OnRetry: func(e RetryEvent) {
+			log.Printf("[Retry] (%s) Tentativa %d falhou: %v — retry em %v", e.Policy, e.Attempt, e.Err, e.Delay)
+		},
+		OnGiveUp: func(e RetryEvent) {
+			log.Printf("[Retry] (%s) Desistindo após %d tentativa(s) em %v (%s): %v", e.Policy, e.Attempt, e.Elapsed, e.Reason, e.Err)
+		},
+	}
+}
+
+func metricsRetryHooks(m *RetryMetrics) RetryHooks {
+	return RetryHooks{
+		OnRetry:   func(RetryEvent) { m.Retries.Add(1) },
+		OnGiveUp:  func(RetryEvent) { m.GiveUps.Add(1) },
+		OnSuccess: func(RetryEvent) { m.Successes.Add(1) },
+	}
+}
+
+func (p *RetryPolicy) emit(pick func(RetryHooks) func(RetryEvent), e RetryEvent) {
+	for _, h := range p.Hooks {
+		if fn := pick(h); fn != nil {
+			fn(e)
+		}
+	}
+}
+
+// Calcula o tempo de espera antes da próxima tentativa (attempt começa em 1)
+func (p *RetryPolicy) backoff(attempt int, prev time.Duration) time.Duration {
+	exp := p.BaseDelay << (attempt - 1)
+	if exp <= 0 || (p.MaxDelay > 0 && exp > p.MaxDelay) {
+		exp = p.MaxDelay
+	}
+
+	switch p.Jitter {
+	case JitterFull:
+		return randDuration(0, exp)
+	case JitterEqual:
+		return exp/2 + randDuration(0, exp/2)
+	case JitterDecorrelated:
+		d := randDuration(p.BaseDelay, max(prev*3, p.BaseDelay))
+		if p.MaxDelay > 0 && d > p.MaxDelay {
+			d = p.MaxDelay
+		}
+		return d
+	}
+	return exp
+}
+
+func randDuration(from, to time.Duration) time.Duration {
+	if to <= from {
+		return from
+	}
+	return from + rand.N(to-from)
+}
+
+// Função De Retry
+// T = tipo de retorno
+// ctx = contexto da requisição; não há novas tentativas depois que o prazo acaba
+// policy = política de retry (tentativas, backoff, jitter, classificação de erros e hooks)
+// fn = função a ser chamada
+func retry[T any](ctx context.Context, policy *RetryPolicy, fn func() (T, error)) (T, error) {
+	var zero T
+	var err error
+
+	start := time.Now()
+	delay := policy.BaseDelay
+	policy.Metrics.Calls.Add(1)
+
+	retryable := isRetryable
+	if policy.Retryable != nil {
+		retryable = policy.Retryable
+	}
+
+	for attempt := 1; ; attempt++ {
+		var result T
+		policy.Metrics.Attempts.Add(1)
+		result, err = fn()
+		event := RetryEvent{Policy: policy.Name, Attempt: attempt, Err: err, Elapsed: time.Since(start)}
+		if err == nil {
+			policy.emit(func(h RetryHooks) func(RetryEvent) { return h.OnSuccess }, event)
+			return result, nil
+		}
+
+		switch {
+		case ctx.Err() != nil:
+			event.Reason = "prazo da requisição esgotado"
+		case !retryable(err):
+			event.Reason = "erro não retentável"
+		case attempt >= policy.MaxAttempts:
+			event.Reason = "tentativas esgotadas"
+		default:
+			delay = policy.backoff(attempt, delay)
+			if policy.MaxElapsed > 0 && event.Elapsed+delay > policy.MaxElapsed {
+				event.Reason = "tempo total máximo excedido"
+			} else if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+				event.Reason = "prazo restante insuficiente"
+			}
+		}
+
+		if event.Reason != "" {
+			policy.emit(func(h RetryHooks) func(RetryEvent) { return h.OnGiveUp }, event)
+			return zero, err
+		}
+
+		event.Delay = delay
+		policy.emit(func(h RetryHooks) func(RetryEvent) { return h.OnRetry }, event)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			event.Reason = "prazo da requisição esgotado"
+			policy.emit(func(h RetryHooks) func(RetryEvent) { return h.OnGiveUp }, event)
+			return zero, err
+		}
+	}
+}
+
+func (p *RetryPolicy) Status() RetryPolicyStatus {
+	return RetryPolicyStatus{
+		Name:        p.Name,
+		MaxAttempts: p.MaxAttempts,
+		BaseDelay:   p.BaseDelay.String(),
+		MaxDelay:    p.MaxDelay.String(),
+		MaxElapsed:  p.MaxElapsed.String(),
+		Jitter:      string(p.Jitter),
+		Calls:       p.Metrics.Calls.Load(),
+		Attempts:    p.Metrics.Attempts.Load(),
+		Retries:     p.Metrics.Retries.Load(),
+		Successes:   p.Metrics.Successes.Load(),
+		GiveUps:     p.Metrics.GiveUps.Load(),
+	}
+}
+
+type RetryPolicies struct {
+	Flight   *RetryPolicy
+	Exchange *RetryPolicy
+	Sell     *RetryPolicy
+	Hold     *RetryPolicy
+	Fidelity *RetryPolicy
+}
+
+func newRetryPolicies(c RetryConfigs) RetryPolicies {
+	return RetryPolicies{
+		Flight:   NewRetryPolicy("airlineshub-flight", c.Flight, isRetryable),
+		Exchange: NewRetryPolicy("exchange-convert", c.Exchange, isRetryable),
+		Sell:     NewRetryPolicy("airlineshub-sell", c.Sell, isConnectionError),
+		// reserva e confirmação de hold são idempotentes: podem ser repetidas como qualquer leitura
+		Hold:     NewRetryPolicy("airlineshub-hold", c.Sell, isRetryable),
+		Fidelity: NewRetryPolicy("fidelity-bonus", c.Fidelity, isRetryable),
+	}
+}
+
+func (p RetryPolicies) All() []*RetryPolicy {
+	return []*RetryPolicy{p.Flight, p.Exchange, p.Sell, p.Hold, p.Fidelity}
+}
+
+var retryPolicies = newRetryPolicies(cfg.Retry)
+
+func retryPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	statuses := []RetryPolicyStatus{}
+	for _, p := range retryPolicies.All() {
+		statuses = append(statuses, p.Status())
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
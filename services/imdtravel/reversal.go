@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+type FidelityReversalRequest struct {
+	TransactionID string `json:"transactionID"`
+	User          string `json:"user"`
+	Reason        string `json:"reason,omitempty"`
+}
+
+// Estorno registrado no Fidelity
+type FidelityReversal struct {
+	TransactionID string `json:"transactionID"`
+	User          string `json:"user"`
+	Status        string `json:"status"` // REVERSED, PARTIAL ou NO_CREDIT
+	Points        int    `json:"points"`
+	Reversed      int    `json:"reversed"`
+	Shortfall     int    `json:"shortfall"` // pontos que não foram estornados por falta de saldo
+}
+
+// Envia um estorno da fila ao Fidelity. O estorno é idempotente pelo transactionID da venda,
+// então reenviá-lo (retry da fila, re-drive do dead-letter) não estorna o bônus duas vezes.
+func trySendReversalRequest(ctx context.Context, pending PendingBonus) (int, error) {
+	request := FidelityReversalRequest{
+		TransactionID: pending.Request.TransactionID,
+		User:          pending.Request.User,
+		Reason:        pending.Reason,
+	}
+	log.Printf("Iniciando estorno do bônus da venda %s do usuário %s", request.TransactionID, request.User)
+
+	endpoint := fmt.Sprintf("%s/reversals", cfg.URL.Fidelity)
+	reqData, err := json.Marshal(request)
+	if err != nil {
+		return 0, fmt.Errorf("falha ao serializar request body: %w", err)
+	}
+
+	req, err := newRequest(ctx, "POST", endpoint, bytes.NewBuffer(reqData))
+	if err != nil {
+		return 0, fmt.Errorf("falha ao montar requisição POST para %s: %w", endpoint, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("ERRO: falha ao enviar requisição POST para fidelity (%s): %v", endpoint, err)
+		return 0, fmt.Errorf("falha ao enviar requisição POST para %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, &HTTPStatusError{Service: "Fidelity", StatusCode: resp.StatusCode, Message: string(bytes.TrimSpace(bodyBytes))}
+	}
+
+	var reversal FidelityReversal
+	if err := json.NewDecoder(resp.Body).Decode(&reversal); err != nil {
+		return resp.StatusCode, fmt.Errorf("falha ao decodificar resposta do Fidelity: %w", err)
+	}
+
+	switch reversal.Status {
+	case "PARTIAL":
+		log.Printf("AVISO: saldo insuficiente no Fidelity, estornados %d pontos do usuário %s (venda %s), %d pontos não foram estornados",
+			reversal.Reversed, reversal.User, reversal.TransactionID, reversal.Shortfall)
+	case "NO_CREDIT":
+		log.Printf("Bônus da venda %s ainda não creditado no Fidelity, estorno registrado", reversal.TransactionID)
+	default:
+		log.Printf("Estornados %d pontos do usuário %s (venda %s)", reversal.Reversed, reversal.User, reversal.TransactionID)
+	}
+	return resp.StatusCode, nil
+}
+
+// Agenda o estorno do bônus do ticket pela fila de entregas ao Fidelity.
+// O estorno sempre passa pela fila: ela é persistente e entrega os bônus e estornos de um usuário em ordem.
+func ReverseBonus(ticket Ticket, reason string) error {
+	if !ticket.TransactionID.Valid {
+		return nil
+	}
+	log.Printf("Agendando estorno do bônus da venda %s do usuário %s", ticket.TransactionID.UUID, ticket.UserID)
+	return pendingBonusQueue.EnqueueReversal(ticket.UserID, ticket.TransactionID.UUID, reason)
+}
+
+// POST /tickets/{transactionID}/cancel
+// Cancela uma compra paga: estorna o bônus, cancela o ticket e a venda no AirlinesHub e devolve os pontos
+// usados no resgate. Responde 200 com o ticket cancelado, ou 202 se alguma etapa falhou e o cancelamento
+// continua em segundo plano. Cancelar de novo um ticket cancelado não tem efeito.
+func cancelTicketHandler(w http.ResponseWriter, r *http.Request) {
+	ticket, apiErr := findTicket(r.PathValue("transactionID"))
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	switch ticket.Status {
+	case "CANCELLED":
+		writeJSON(w, http.StatusOK, ticket)
+		return
+	case "PAID":
+	default:
+		writeError(w, newAPIError(http.StatusConflict, fmt.Errorf("ticket %s está %s, só tickets PAID podem ser cancelados", ticket.ID, ticket.Status)))
+		return
+	}
+
+	done, err := sagas.Cancel(ticket)
+	if err != nil {
+		writeError(w, newAPIError(http.StatusConflict, err))
+		return
+	}
+
+	ticket, _ = ticketDB.Get(ticket.ID)
+	if !done {
+		writeJSON(w, http.StatusAccepted, ticket)
+		return
+	}
+	writeJSON(w, http.StatusOK, ticket)
+}
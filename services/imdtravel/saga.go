@@ -0,0 +1,530 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fsousabt/shared/journal"
+	"github.com/google/uuid"
+)
+
+type SagaState string
+
+const (
+	SagaRunning      SagaState = "RUNNING"
+	SagaCompleted    SagaState = "COMPLETED"
+	SagaCompensating SagaState = "COMPENSATING"
+	SagaCompensated  SagaState = "COMPENSATED"
+	// a venda terminou em timeout (ou foi interrompida por um reinício) e pode ter acontecido no AirlinesHub:
+	// a compra aguarda o Reconciler conferir a venda para ser concluída ou desfeita
+	SagaUncertain SagaState = "UNCERTAIN"
+	// o Reconciler não conseguiu conferir a venda incerta: a compra fica parada aguardando análise manual
+	SagaManualReview SagaState = "MANUAL_REVIEW"
+)
+
+// Estado persistido de uma compra. Cada mudança é gravada no saga log antes e depois de cada passo,
+// para que uma compra interrompida por um reinício possa ser retomada ou compensada.
+type PurchaseSaga struct {
+	ID        uuid.UUID `json:"id"`
+	State     SagaState `json:"state"`
+	Step      string    `json:"step"`     // último passo iniciado
+	StepDone  bool      `json:"stepDone"` // o último passo iniciado terminou com sucesso
+	Ft        bool      `json:"ft"`
+	Bonus     int       `json:"bonus"`
+	Ticket    Ticket    `json:"ticket"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type sagaStep struct {
+	name string
+	// ação do passo
+	action func(ctx context.Context, s *PurchaseSaga) error
+	// desfaz o passo; deve ser idempotente e funcionar mesmo se a ação não chegou a acontecer
+	compensate func(ctx context.Context, s *PurchaseSaga) error
+}
+
+var purchaseSteps = []sagaStep{
+	// a liberação da reserva também devolve os pontos se o débito já tiver acontecido
+	{name: "reserve", action: reservePointsStep, compensate: releasePointsStep},
+	{name: "sell", action: sellStep, compensate: cancelSellStep},
+	{name: "redeem", action: redeemPointsStep},
+	{name: "confirm", action: confirmTicketStep, compensate: cancelTicketStep},
+	// último passo: falhas no envio do bônus não desfazem a compra (o bônus fica na fila de pendentes).
+	// O estorno só acontece no cancelamento de uma compra concluída.
+	{name: "bonus", action: bonusStep, compensate: reverseBonusStep},
+}
+
+func stepIndex(name string) int {
+	for i, step := range purchaseSteps {
+		if step.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func reservePointsStep(ctx context.Context, s *PurchaseSaga) error {
+	if s.Ticket.Points == 0 {
+		return nil
+	}
+	return ReservePoints(ctx, s.Ft, s.Ticket)
+}
+
+func releasePointsStep(ctx context.Context, s *PurchaseSaga) error {
+	if s.Ticket.Points == 0 {
+		return nil
+	}
+	return ReleasePoints(ctx, s.Ticket)
+}
+
+func redeemPointsStep(ctx context.Context, s *PurchaseSaga) error {
+	if s.Ticket.Points == 0 {
+		return nil
+	}
+	return RedeemPoints(ctx, s.Ft, s.Ticket)
+}
+
+// Confirma a reserva do assento (hold), o que cria a venda no AirlinesHub.
+// Compras sem hold (registradas antes das reservas existirem) usam a venda direta pelo /sell.
+func sellStep(ctx context.Context, s *PurchaseSaga) error {
+	var transactionID uuid.UUID
+	var err error
+	if s.Ticket.HoldID != "" {
+		transactionID, err = ConfirmHold(ctx, s.Ft, s.Ticket.HoldID)
+	} else if s.Ft {
+		transactionID, err = callWithBreaker(ctx, breakers.Sell, func() (uuid.UUID, error) {
+			return retry(ctx, retryPolicies.Sell, func() (uuid.UUID, error) {
+				return RequestTicketSell(ctx, s.Ft, s.Ticket.FlightNumber, s.Ticket.FlightDay, s.Ticket.IdempotencyKey)
+			})
+		})
+	} else {
+		transactionID, err = RequestTicketSell(ctx, s.Ft, s.Ticket.FlightNumber, s.Ticket.FlightDay, s.Ticket.IdempotencyKey)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.Ticket.TransactionID = uuid.NullUUID{UUID: transactionID, Valid: true}
+	return nil
+}
+
+// Cancela a venda. Com hold, basta liberá-lo: o AirlinesHub devolve o assento ou, se a confirmação
+// chegou a acontecer (ex: timeout na resposta), cancela a venda.
+// Sem hold, se o transactionID não é conhecido, procura a venda pelo Idempotency-Key,
+// já que ela pode ter sido concluída no AirlinesHub mesmo assim.
+func cancelSellStep(ctx context.Context, s *PurchaseSaga) error {
+	if s.Ticket.HoldID != "" {
+		return ReleaseHold(ctx, s.Ticket.HoldID)
+	}
+
+	transactionID := s.Ticket.TransactionID
+	if !transactionID.Valid {
+		id, found, err := FindSaleByIdempotencyKey(ctx, s.Ticket.IdempotencyKey)
+		if err != nil {
+			return err
+		}
+		if !found {
+			log.Printf("[Saga] (%s) Nenhuma venda encontrada no AirlinesHub, nada a cancelar", s.ID)
+			return nil
+		}
+		transactionID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	return CancelTicketSell(ctx, transactionID.UUID)
+}
+
+func confirmTicketStep(ctx context.Context, s *PurchaseSaga) error {
+	s.Ticket.Status = "PAID"
+	if err := saveTicket(&s.Ticket); err != nil {
+		return fmt.Errorf("falha ao armazenar ticket pago: %w", err)
+	}
+	log.Printf("Ticket armazenado no 'banco de dados' local: %+v", s.Ticket)
+	return nil
+}
+
+func cancelTicketStep(ctx context.Context, s *PurchaseSaga) error {
+	if s.Ticket.Status != "PAID" {
+		return nil
+	}
+	s.Ticket.Status = "CANCELLED"
+	return saveTicket(&s.Ticket)
+}
+
+func bonusStep(ctx context.Context, s *PurchaseSaga) error {
+	log.Printf("Enviando bônus de %d para usuário %s", s.Bonus, s.Ticket.UserID)
+
+	_, err := SendFidelityRequest(ctx, s.Ft, FidelityRequest{
+		User:          s.Ticket.UserID,
+		Bonus:         s.Bonus,
+		TransactionID: s.Ticket.TransactionID.UUID.String(),
+	})
+	if err != nil {
+		log.Printf("AVISO: Falha ao enviar bônus da venda %s: %v", s.Ticket.TransactionID.UUID, err)
+	} else {
+		log.Printf("Sucesso: Bônus enviado para usuário %s", s.Ticket.UserID)
+	}
+	return nil
+}
+
+func reverseBonusStep(ctx context.Context, s *PurchaseSaga) error {
+	return ReverseBonus(s.Ticket, s.Error)
+}
+
+// Coordena as sagas de compra e mantém o saga log (journal) das que ainda não terminaram
+type SagaCoordinator struct {
+	mu sync.Mutex
+
+	sagas         map[uuid.UUID]PurchaseSaga // sagas não terminadas
+	running       map[uuid.UUID]bool         // sagas sendo executadas/compensadas neste momento
+	journal       *journal.Journal
+	snapshotEvery int
+	retryInterval time.Duration
+}
+
+func NewSagaCoordinator(c SagaConfig) (*SagaCoordinator, error) {
+	journal, err := journal.Open(c.Dir, "sagas", c.Journal)
+	if err != nil {
+		return nil, err
+	}
+
+	coordinator := &SagaCoordinator{
+		sagas:         make(map[uuid.UUID]PurchaseSaga),
+		running:       make(map[uuid.UUID]bool),
+		journal:       journal,
+		snapshotEvery: max(c.SnapshotEvery, 1),
+		retryInterval: c.RetryInterval,
+	}
+
+	err = journal.Load(func(data []byte) error {
+		var saga PurchaseSaga
+		if err := json.Unmarshal(data, &saga); err != nil {
+			return err
+		}
+		if saga.State == SagaCompleted || saga.State == SagaCompensated {
+			delete(coordinator.sagas, saga.ID)
+		} else {
+			coordinator.sagas[saga.ID] = saga
+		}
+		return nil
+	})
+	if err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("falha ao recuperar saga log: %w", err)
+	}
+
+	return coordinator, nil
+}
+
+func (c *SagaCoordinator) persist(s *PurchaseSaga) error {
+	s.UpdatedAt = time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// o estado em memória só muda depois de gravado: após uma falha ele continua igual ao do saga log
+	if err := c.journal.Append(s); err != nil {
+		log.Printf("[Saga] (%s) ERRO: falha ao gravar saga log: %v", s.ID, err)
+		return err
+	}
+
+	if s.State == SagaCompleted || s.State == SagaCompensated {
+		delete(c.sagas, s.ID)
+	} else {
+		c.sagas[s.ID] = *s
+	}
+
+	if c.journal.Records() >= c.snapshotEvery {
+		records := make([]any, 0, len(c.sagas))
+		for _, saga := range c.sagas {
+			records = append(records, saga)
+		}
+		if err := c.journal.Snapshot(records); err != nil {
+			log.Printf("[Saga] ERRO: falha ao gravar snapshot do saga log: %v", err)
+		}
+	}
+	return nil
+}
+
+// Marca a saga como em execução; retorna false se ela já está sendo executada
+func (c *SagaCoordinator) acquire(id uuid.UUID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running[id] {
+		return false
+	}
+	c.running[id] = true
+	return true
+}
+
+func (c *SagaCoordinator) release(id uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.running, id)
+}
+
+// Begin registra uma nova saga de compra no saga log
+func (c *SagaCoordinator) Begin(ticket Ticket) (*PurchaseSaga, error) {
+	saga := &PurchaseSaga{
+		ID:     ticket.ID,
+		State:  SagaRunning,
+		Ft:     ticket.Ft,
+		Bonus:  ticket.Bonus,
+		Ticket: ticket,
+	}
+	if err := c.persist(saga); err != nil {
+		return nil, err
+	}
+	return saga, nil
+}
+
+// Run executa os passos da saga a partir do ponto em que ela parou.
+// Se um passo falhar, a saga passa a COMPENSATING e a compensação roda em segundo plano,
+// a não ser que a venda tenha ficado incerta (UNCERTAIN): aí quem decide é o Reconciler.
+func (c *SagaCoordinator) Run(ctx context.Context, s *PurchaseSaga) error {
+	if !c.acquire(s.ID) {
+		return fmt.Errorf("saga %s já está em execução", s.ID)
+	}
+
+	err := c.runSteps(ctx, s)
+	c.release(s.ID)
+
+	if err != nil && s.State == SagaCompensating {
+		go c.compensate(*s)
+	}
+	return err
+}
+
+func (c *SagaCoordinator) runSteps(ctx context.Context, s *PurchaseSaga) error {
+	start := 0
+	if s.Step != "" {
+		start = stepIndex(s.Step)
+		if s.StepDone {
+			start++
+		}
+	}
+
+	for _, step := range purchaseSteps[start:] {
+		s.Step = step.name
+		s.StepDone = false
+		if err := c.persist(s); err != nil {
+			// sem o registro do passo um reinício não saberia que ele começou: a compra é desfeita sem executá-lo
+			err = fmt.Errorf("falha ao gravar o início do passo '%s' no saga log: %w", step.name, err)
+			log.Printf("[Saga] (%s) %v", s.ID, err)
+			s.Ticket.FailureReason = err.Error()
+			s.Ticket.Status = "FAILED"
+			s.Error = err.Error()
+			s.State = SagaCompensating
+			saveTicket(&s.Ticket)
+			c.checkpoint(s)
+			return err
+		}
+
+		if err := step.action(ctx, s); err != nil {
+			log.Printf("[Saga] (%s) Passo '%s' falhou: %v", s.ID, step.name, err)
+			s.Ticket.FailureReason = err.Error()
+			s.Error = err.Error()
+			if step.name == "sell" && errors.Is(err, ErrTicketSellTimeout) {
+				log.Printf("[Saga] (%s) Venda incerta, aguardando reconciliação com o AirlinesHub", s.ID)
+				s.Ticket.Status = "RECONCILING"
+				s.State = SagaUncertain
+			} else {
+				s.Ticket.Status = "FAILED"
+				s.State = SagaCompensating
+			}
+			saveTicket(&s.Ticket)
+			c.checkpoint(s)
+			return err
+		}
+
+		s.StepDone = true
+		c.checkpoint(s)
+	}
+
+	s.State = SagaCompleted
+	c.checkpoint(s)
+	return nil
+}
+
+// checkpoint grava um estado da saga que já aconteceu e não pode ser desfeito por uma falha no saga log.
+// A execução segue, mas o coordenador (e um reinício) continua vendo o último estado gravado,
+// então a falha só é registrada.
+func (c *SagaCoordinator) checkpoint(s *PurchaseSaga) {
+	if err := c.persist(s); err != nil {
+		log.Printf("[Saga] (%s) AVISO: estado %s (passo '%s') não gravado no saga log; após um reinício a saga "+
+			"é retomada a partir do último estado gravado", s.ID, s.State, s.Step)
+	}
+}
+
+// Cancel desfaz uma compra concluída: a saga do ticket volta como COMPENSATING a partir do último passo
+// e as compensações de todos os passos são executadas (estorno do bônus, cancelamento do ticket e da venda,
+// devolução dos pontos), com as mesmas opções (ft e bônus) gravadas no ticket pela compra. Retorna done=false se alguma compensação falhou; nesse caso ela é tentada de novo
+// pelo recoveryLoop.
+func (c *SagaCoordinator) Cancel(ticket Ticket) (done bool, err error) {
+	c.mu.Lock()
+	_, pending := c.sagas[ticket.ID]
+	c.mu.Unlock()
+	if pending {
+		return false, fmt.Errorf("compra %s ainda em andamento", ticket.ID)
+	}
+
+	saga := &PurchaseSaga{
+		ID:       ticket.ID,
+		State:    SagaCompensating,
+		Step:     purchaseSteps[len(purchaseSteps)-1].name,
+		StepDone: true,
+		Ft:       ticket.Ft,
+		Bonus:    ticket.Bonus,
+		Ticket:   ticket,
+		Error:    "compra cancelada pelo cliente",
+	}
+	if err := c.persist(saga); err != nil {
+		return false, err
+	}
+	log.Printf("[Saga] (%s) Cancelando compra do usuário %s", saga.ID, ticket.UserID)
+
+	c.compensate(*saga)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, pending = c.sagas[ticket.ID]
+	return !pending, nil
+}
+
+// Executa as compensações do último passo iniciado até o primeiro, em ordem inversa.
+// Se alguma falhar, a saga continua COMPENSATING e é tentada de novo pelo recoveryLoop.
+func (c *SagaCoordinator) compensate(s PurchaseSaga) {
+	if !c.acquire(s.ID) {
+		return
+	}
+	defer c.release(s.ID)
+
+	ctx, cancel := newBackgroundContext()
+	defer cancel()
+
+	log.Printf("[Saga] (%s) Compensando compra a partir do passo '%s'", s.ID, s.Step)
+
+	var errs []error
+	for i := stepIndex(s.Step); i >= 0; i-- {
+		step := purchaseSteps[i]
+		if step.compensate == nil {
+			continue
+		}
+		if err := step.compensate(ctx, &s); err != nil {
+			log.Printf("[Saga] (%s) Falha ao compensar passo '%s': %v", s.ID, step.name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", step.name, err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		s.Error = err.Error()
+		c.checkpoint(&s)
+		log.Printf("[Saga] (%s) Compensação incompleta, nova tentativa em %v", s.ID, c.retryInterval)
+		return
+	}
+
+	s.State = SagaCompensated
+	c.checkpoint(&s)
+	log.Printf("[Saga] (%s) Compra compensada com sucesso", s.ID)
+}
+
+// Recover trata as sagas que não terminaram antes do último encerramento do serviço:
+// - se a venda nem começou, a compra é compensada
+// - se a venda foi interrompida, não se sabe se ela aconteceu no AirlinesHub: a compra fica incerta
+// e é conferida pelo Reconciler
+// - se a venda foi concluída, a saga é retomada a partir do passo em que parou
+// Compras incertas ou aguardando análise manual ficam como estão.
+func (c *SagaCoordinator) Recover() {
+	c.mu.Lock()
+	pending := make([]PurchaseSaga, 0, len(c.sagas))
+	for _, saga := range c.sagas {
+		pending = append(pending, saga)
+	}
+	c.mu.Unlock()
+
+	log.Printf("[Saga] %d saga(s) não terminada(s) encontrada(s) no saga log", len(pending))
+
+	for _, saga := range pending {
+		step, sell := stepIndex(saga.Step), stepIndex("sell")
+		if saga.State == SagaRunning && step == sell && !saga.StepDone {
+			log.Printf("[Saga] (%s) Compra interrompida durante a venda, aguardando reconciliação", saga.ID)
+			saga.Ticket.Status = "RECONCILING"
+			saga.Ticket.FailureReason = "venda interrompida por reinício do serviço"
+			saveTicket(&saga.Ticket)
+			saga.State = SagaUncertain
+			saga.Error = saga.Ticket.FailureReason
+			c.checkpoint(&saga)
+		}
+		if saga.State == SagaRunning && step < sell {
+			log.Printf("[Saga] (%s) Compra interrompida antes da venda, compensando", saga.ID)
+			saga.Ticket.Status = "FAILED"
+			saga.Ticket.FailureReason = "compra interrompida por reinício do serviço"
+			saveTicket(&saga.Ticket)
+			saga.State = SagaCompensating
+			saga.Error = saga.Ticket.FailureReason
+			c.checkpoint(&saga)
+		}
+		if saga.State == SagaUncertain || saga.State == SagaManualReview {
+			continue
+		}
+
+		if saga.State == SagaRunning {
+			log.Printf("[Saga] (%s) Retomando compra a partir do passo '%s'", saga.ID, saga.Step)
+			go func(s PurchaseSaga) {
+				ctx, cancel := newBackgroundContext()
+				defer cancel()
+				c.Run(ctx, &s)
+			}(saga)
+			continue
+		}
+
+		go c.compensate(saga)
+	}
+
+	go c.recoveryLoop()
+}
+
+func (c *SagaCoordinator) recoveryLoop() {
+	ticker := time.NewTicker(c.retryInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.mu.Lock()
+		var compensating []PurchaseSaga
+		for _, saga := range c.sagas {
+			if saga.State == SagaCompensating && !c.running[saga.ID] {
+				compensating = append(compensating, saga)
+			}
+		}
+		c.mu.Unlock()
+
+		for _, saga := range compensating {
+			c.compensate(saga)
+		}
+	}
+}
+
+// Uncertain retorna as sagas com a venda incerta que não estão sendo executadas neste momento
+func (c *SagaCoordinator) Uncertain() []PurchaseSaga {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var uncertain []PurchaseSaga
+	for _, saga := range c.sagas {
+		if saga.State == SagaUncertain && !c.running[saga.ID] {
+			uncertain = append(uncertain, saga)
+		}
+	}
+	return uncertain
+}
+
+func (c *SagaCoordinator) Close() error {
+	return c.journal.Close()
+}
+
+var sagas *SagaCoordinator
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsousabt/shared/journal"
+	"github.com/google/uuid"
+)
+
+// Registra as ações e compensações executadas pelos passos falsos da saga
+type stepRecorder struct {
+	mu    sync.Mutex
+	calls []string
+	fail  map[string]error // erro retornado por "action:<passo>" ou "compensate:<passo>"
+	sagas []PurchaseSaga   // saga recebida em cada chamada
+}
+
+func (r *stepRecorder) call(kind, name string, s *PurchaseSaga) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	call := kind + ":" + name
+	r.calls = append(r.calls, call)
+	r.sagas = append(r.sagas, *s)
+	return r.fail[call]
+}
+
+func (r *stepRecorder) Calls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.calls)
+}
+
+// Troca os passos da compra por passos falsos com os mesmos nomes (e sem compensação onde o original não tem)
+func fakePurchaseSteps(t *testing.T, fail map[string]error) *stepRecorder {
+	t.Helper()
+	recorder := &stepRecorder{fail: fail}
+	steps := make([]sagaStep, len(purchaseSteps))
+	for i, step := range purchaseSteps {
+		name := step.name
+		steps[i] = sagaStep{name: name, action: func(ctx context.Context, s *PurchaseSaga) error {
+			return recorder.call("action", name, s)
+		}}
+		if step.compensate != nil {
+			steps[i].compensate = func(ctx context.Context, s *PurchaseSaga) error {
+				return recorder.call("compensate", name, s)
+			}
+		}
+	}
+	previous := purchaseSteps
+	purchaseSteps = steps
+	t.Cleanup(func() { purchaseSteps = previous })
+	return recorder
+}
+
+func openTestSagas(t *testing.T, dir string) *SagaCoordinator {
+	t.Helper()
+	c, err := NewSagaCoordinator(SagaConfig{
+		Dir:           dir,
+		Journal:       journal.Config{Sync: journal.SyncNever},
+		SnapshotEvery: 1000,
+		RetryInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewSagaCoordinator: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func useTestTicketStore(t *testing.T) {
+	t.Helper()
+	previous := ticketDB
+	ticketDB = NewMemoryTicketStore()
+	t.Cleanup(func() { ticketDB = previous })
+}
+
+func testTicket() Ticket {
+	return Ticket{ID: uuid.New(), UserID: "ana", Ft: true, Bonus: 42, Status: "PENDING_PAYMENT"}
+}
+
+// Espera a saga chegar ao estado want sem estar em execução. Sagas concluídas ou compensadas saem do coordenador.
+func waitForSaga(t *testing.T, c *SagaCoordinator, id uuid.UUID, want SagaState) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c.mu.Lock()
+		saga, pending := c.sagas[id]
+		running := c.running[id]
+		c.mu.Unlock()
+
+		done := !pending
+		if want != SagaCompleted && want != SagaCompensated {
+			done = pending && saga.State == want
+		}
+		if done && !running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("saga no estado %s (pendente=%v, em execução=%v), want %s", saga.State, pending, running, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSagaRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		fail       map[string]error
+		wantState  SagaState
+		wantStatus string // status do ticket gravado pela saga; vazio se ela não grava
+		wantCalls  []string
+	}{
+		{
+			name:      "compra concluída",
+			wantState: SagaCompleted,
+			wantCalls: []string{"action:reserve", "action:sell", "action:redeem", "action:confirm", "action:bonus"},
+		},
+		{
+			name:       "falha no resgate compensa a venda e a reserva",
+			fail:       map[string]error{"action:redeem": errors.New("saldo insuficiente")},
+			wantState:  SagaCompensated,
+			wantStatus: "FAILED",
+			wantCalls: []string{"action:reserve", "action:sell", "action:redeem",
+				"compensate:sell", "compensate:reserve"},
+		},
+		{
+			name:       "falha na venda compensa também o próprio passo",
+			fail:       map[string]error{"action:sell": errors.New("voo lotado")},
+			wantState:  SagaCompensated,
+			wantStatus: "FAILED",
+			wantCalls:  []string{"action:reserve", "action:sell", "compensate:sell", "compensate:reserve"},
+		},
+		{
+			name:       "timeout na venda deixa a compra incerta",
+			fail:       map[string]error{"action:sell": ErrTicketSellTimeout},
+			wantState:  SagaUncertain,
+			wantStatus: "RECONCILING",
+			wantCalls:  []string{"action:reserve", "action:sell"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestTicketStore(t)
+			recorder := fakePurchaseSteps(t, tt.fail)
+			c := openTestSagas(t, t.TempDir())
+
+			saga, err := c.Begin(testTicket())
+			if err != nil {
+				t.Fatalf("Begin: %v", err)
+			}
+			if err := c.Run(context.Background(), saga); (err != nil) != (tt.wantState != SagaCompleted) {
+				t.Fatalf("Run err = %v", err)
+			}
+			waitForSaga(t, c, saga.ID, tt.wantState)
+
+			if calls := recorder.Calls(); !slices.Equal(calls, tt.wantCalls) {
+				t.Fatalf("passos = %v, want %v", calls, tt.wantCalls)
+			}
+			ticket, _ := ticketDB.Get(saga.ID)
+			if ticket.Status != tt.wantStatus {
+				t.Fatalf("status do ticket = %q, want %q", ticket.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestSagaRecover(t *testing.T) {
+	tests := []struct {
+		name       string
+		saga       PurchaseSaga // estado gravado no saga log antes do reinício
+		wantState  SagaState
+		wantStatus string
+		wantCalls  []string
+	}{
+		{
+			name:       "interrompida antes da venda é compensada",
+			saga:       PurchaseSaga{State: SagaRunning, Step: "reserve", StepDone: true},
+			wantState:  SagaCompensated,
+			wantStatus: "FAILED",
+			wantCalls:  []string{"compensate:reserve"},
+		},
+		{
+			name:       "interrompida durante a venda fica incerta",
+			saga:       PurchaseSaga{State: SagaRunning, Step: "sell"},
+			wantState:  SagaUncertain,
+			wantStatus: "RECONCILING",
+		},
+		{
+			name:      "interrompida depois da venda é retomada",
+			saga:      PurchaseSaga{State: SagaRunning, Step: "sell", StepDone: true},
+			wantState: SagaCompleted,
+			wantCalls: []string{"action:redeem", "action:confirm", "action:bonus"},
+		},
+		{
+			name:      "passo interrompido depois da venda é refeito",
+			saga:      PurchaseSaga{State: SagaRunning, Step: "confirm"},
+			wantState: SagaCompleted,
+			wantCalls: []string{"action:confirm", "action:bonus"},
+		},
+		{
+			name:      "compensação interrompida é retomada",
+			saga:      PurchaseSaga{State: SagaCompensating, Step: "confirm", StepDone: true},
+			wantState: SagaCompensated,
+			wantCalls: []string{"compensate:confirm", "compensate:sell", "compensate:reserve"},
+		},
+		{
+			name:      "venda incerta aguarda o Reconciler",
+			saga:      PurchaseSaga{State: SagaUncertain, Step: "sell"},
+			wantState: SagaUncertain,
+		},
+		{
+			name:      "análise manual não é tocada",
+			saga:      PurchaseSaga{State: SagaManualReview, Step: "sell"},
+			wantState: SagaManualReview,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestTicketStore(t)
+			recorder := fakePurchaseSteps(t, nil)
+			dir := t.TempDir()
+
+			saga := tt.saga
+			saga.Ticket = testTicket()
+			saga.ID = saga.Ticket.ID
+			before := openTestSagas(t, dir)
+			if err := before.persist(&saga); err != nil {
+				t.Fatalf("persist: %v", err)
+			}
+			before.Close()
+
+			c := openTestSagas(t, dir)
+			c.Recover()
+			waitForSaga(t, c, saga.ID, tt.wantState)
+
+			if calls := recorder.Calls(); !slices.Equal(calls, tt.wantCalls) {
+				t.Fatalf("passos = %v, want %v", calls, tt.wantCalls)
+			}
+			ticket, _ := ticketDB.Get(saga.ID)
+			if ticket.Status != tt.wantStatus {
+				t.Fatalf("status do ticket = %q, want %q", ticket.Status, tt.wantStatus)
+			}
+
+			// o estado final também é o que um novo reinício encontra no saga log
+			c.Close()
+			after := openTestSagas(t, dir)
+			after.mu.Lock()
+			recovered, pending := after.sagas[saga.ID]
+			after.mu.Unlock()
+			if terminal := tt.wantState == SagaCompleted || tt.wantState == SagaCompensated; pending == terminal ||
+				(pending && recovered.State != tt.wantState) {
+				t.Fatalf("saga log após reinício: pendente=%v estado=%s, want %s", pending, recovered.State, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestSagaCancelRetriesFailedCompensation(t *testing.T) {
+	useTestTicketStore(t)
+	recorder := fakePurchaseSteps(t, map[string]error{"compensate:bonus": errors.New("Fidelity indisponível")})
+	c := openTestSagas(t, t.TempDir())
+
+	ticket := testTicket()
+	ticket.Status = "PAID"
+	done, err := c.Cancel(ticket)
+	if err != nil || done {
+		t.Fatalf("Cancel = %v, %v; want compensação incompleta", done, err)
+	}
+	// uma compensação que falhou não impede as dos passos anteriores
+	want := []string{"compensate:bonus", "compensate:confirm", "compensate:sell", "compensate:reserve"}
+	if calls := recorder.Calls(); !slices.Equal(calls, want) {
+		t.Fatalf("compensações = %v, want %v", calls, want)
+	}
+	// o cancelamento usa as opções gravadas no ticket pela compra
+	for _, s := range recorder.sagas {
+		if s.Ft != ticket.Ft || s.Bonus != ticket.Bonus {
+			t.Fatalf("compensação com ft=%v bonus=%d, want ft=%v bonus=%d", s.Ft, s.Bonus, ticket.Ft, ticket.Bonus)
+		}
+	}
+
+	c.mu.Lock()
+	saga := c.sagas[ticket.ID]
+	c.mu.Unlock()
+	if saga.State != SagaCompensating || saga.Error == "" {
+		t.Fatalf("saga após falha = %s (%q), want %s com o erro", saga.State, saga.Error, SagaCompensating)
+	}
+	if _, err := c.Cancel(ticket); err == nil {
+		t.Fatalf("Cancel de uma compra ainda compensando não falhou")
+	}
+
+	// nova tentativa, como a do recoveryLoop
+	recorder.mu.Lock()
+	recorder.fail = nil
+	recorder.mu.Unlock()
+	c.compensate(saga)
+	waitForSaga(t, c, ticket.ID, SagaCompensated)
+	if calls := recorder.Calls(); !slices.Equal(calls[len(want):], want) {
+		t.Fatalf("compensações na nova tentativa = %v, want %v", calls[len(want):], want)
+	}
+}
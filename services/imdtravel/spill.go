@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Arquivo FIFO com os bônus que não cabem na memória da fila (política de overflow "spill").
+// Ele não precisa de fsync: o journal da fila continua sendo a fonte da verdade e o arquivo
+// é recriado a partir dele quando o serviço reinicia.
+type bonusSpill struct {
+	path   string
+	file   *os.File
+	offset int64 // posição do próximo bônus a ser lido
+	size   int64
+	count  int
+}
+
+func openBonusSpill(dir string) (*bonusSpill, error) {
+	path := filepath.Join(dir, "bonus-queue.spill")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao abrir arquivo de spill %s: %w", path, err)
+	}
+	return &bonusSpill{path: path, file: file}, nil
+}
+
+func (s *bonusSpill) Push(bonus PendingBonus) error {
+	data, err := json.Marshal(bonus)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := s.file.WriteAt(data, s.size); err != nil {
+		return fmt.Errorf("falha ao escrever no arquivo de spill %s: %w", s.path, err)
+	}
+	s.size += int64(len(data))
+	s.count++
+	return nil
+}
+
+// Pop lê o bônus mais antigo do arquivo. Quando o arquivo esvazia ele é truncado.
+func (s *bonusSpill) Pop() (PendingBonus, bool, error) {
+	if s.count == 0 {
+		return PendingBonus{}, false, nil
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(s.file, s.offset, s.size-s.offset))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return PendingBonus{}, false, fmt.Errorf("falha ao ler arquivo de spill %s: %w", s.path, err)
+	}
+
+	var bonus PendingBonus
+	if err := json.Unmarshal(line, &bonus); err != nil {
+		return PendingBonus{}, false, fmt.Errorf("registro inválido no arquivo de spill %s: %w", s.path, err)
+	}
+
+	s.offset += int64(len(line))
+	s.count--
+	if s.count == 0 {
+		s.offset = 0
+		s.size = 0
+		s.file.Truncate(0)
+	}
+	return bonus, true, nil
+}
+
+// Remaining retorna os bônus ainda no arquivo, sem removê-los
+func (s *bonusSpill) Remaining() ([]PendingBonus, error) {
+	bonuses := make([]PendingBonus, 0, s.count)
+	reader := bufio.NewReader(io.NewSectionReader(s.file, s.offset, s.size-s.offset))
+	for range s.count {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler arquivo de spill %s: %w", s.path, err)
+		}
+		var bonus PendingBonus
+		if err := json.Unmarshal(line, &bonus); err != nil {
+			return nil, fmt.Errorf("registro inválido no arquivo de spill %s: %w", s.path, err)
+		}
+		bonuses = append(bonuses, bonus)
+	}
+	return bonuses, nil
+}
+
+func (s *bonusSpill) Len() int {
+	return s.count
+}
+
+func (s *bonusSpill) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/fsousabt/shared/journal"
+	"github.com/google/uuid"
+)
+
+// Armazenamento de tickets (PENDING_PAYMENT, FAILED e PAID)
+type TicketStore interface {
+	Save(ticket Ticket) error
+	Get(id uuid.UUID) (Ticket, bool)
+	GetByTransactionID(transactionID uuid.UUID) (Ticket, bool)
+	ListByUser(user string) []Ticket
+	List() []Ticket
+	Close() error
+}
+
+// Implementação em memória, segura para uso concorrente: os tickets são perdidos quando o serviço reinicia
+type MemoryTicketStore struct {
+	mu            sync.RWMutex
+	tickets       map[uuid.UUID]Ticket
+	byTransaction map[uuid.UUID]uuid.UUID
+	byUser        map[string][]uuid.UUID
+}
+
+func NewMemoryTicketStore() *MemoryTicketStore {
+	return &MemoryTicketStore{
+		tickets:       make(map[uuid.UUID]Ticket),
+		byTransaction: make(map[uuid.UUID]uuid.UUID),
+		byUser:        make(map[string][]uuid.UUID),
+	}
+}
+
+// Deve ser chamado com s.mu travado
+func (s *MemoryTicketStore) put(ticket Ticket) {
+	if _, exists := s.tickets[ticket.ID]; !exists {
+		s.byUser[ticket.UserID] = append(s.byUser[ticket.UserID], ticket.ID)
+	}
+	if ticket.TransactionID.Valid {
+		s.byTransaction[ticket.TransactionID.UUID] = ticket.ID
+	}
+	s.tickets[ticket.ID] = ticket
+}
+
+func (s *MemoryTicketStore) Save(ticket Ticket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(ticket)
+	return nil
+}
+
+func (s *MemoryTicketStore) Get(id uuid.UUID) (Ticket, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ticket, ok := s.tickets[id]
+	return ticket, ok
+}
+
+func (s *MemoryTicketStore) GetByTransactionID(transactionID uuid.UUID) (Ticket, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, ok := s.byTransaction[transactionID]
+	if !ok {
+		return Ticket{}, false
+	}
+	ticket, ok := s.tickets[id]
+	return ticket, ok
+}
+
+func (s *MemoryTicketStore) ListByUser(user string) []Ticket {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := s.byUser[user]
+	tickets := make([]Ticket, 0, len(ids))
+	for _, id := range ids {
+		tickets = append(tickets, s.tickets[id])
+	}
+	return tickets
+}
+
+func (s *MemoryTicketStore) List() []Ticket {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tickets := make([]Ticket, 0, len(s.tickets))
+	for _, ticket := range s.tickets {
+		tickets = append(tickets, ticket)
+	}
+	return tickets
+}
+
+func (s *MemoryTicketStore) Close() error {
+	return nil
+}
+
+// Implementação em arquivo: cada alteração de ticket é gravada em um journal append-only,
+// compactado em snapshot a cada snapshotEvery registros, e recuperada na inicialização.
+type FileTicketStore struct {
+	*MemoryTicketStore
+
+	journal       *journal.Journal
+	snapshotEvery int
+}
+
+func NewFileTicketStore(c TicketStoreConfig) (*FileTicketStore, error) {
+	journal, err := journal.Open(c.Dir, "tickets", c.Journal)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileTicketStore{
+		MemoryTicketStore: NewMemoryTicketStore(),
+		journal:           journal,
+		snapshotEvery:     max(c.SnapshotEvery, 1),
+	}
+
+	err = journal.Load(func(data []byte) error {
+		var ticket Ticket
+		if err := json.Unmarshal(data, &ticket); err != nil {
+			return err
+		}
+		s.put(ticket)
+		return nil
+	})
+	if err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("falha ao recuperar tickets: %w", err)
+	}
+
+	log.Printf("[TicketStore] %d ticket(s) recuperado(s) de %s", len(s.tickets), c.Dir)
+	return s, nil
+}
+
+func (s *FileTicketStore) Save(ticket Ticket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.journal.Append(ticket); err != nil {
+		return err
+	}
+	s.put(ticket)
+
+	if s.journal.Records() >= s.snapshotEvery {
+		s.snapshot()
+	}
+	return nil
+}
+
+// Deve ser chamado com s.mu travado
+func (s *FileTicketStore) snapshot() {
+	records := make([]any, 0, len(s.tickets))
+	for _, ticket := range s.tickets {
+		records = append(records, ticket)
+	}
+	if err := s.journal.Snapshot(records); err != nil {
+		log.Printf("[TicketStore] ERRO: falha ao gravar snapshot: %v", err)
+	}
+}
+
+func (s *FileTicketStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshot()
+	return s.journal.Close()
+}
+
+func NewTicketStore(c TicketStoreConfig) (TicketStore, error) {
+	switch c.Backend {
+	case "memory":
+		return NewMemoryTicketStore(), nil
+	case "file":
+		return NewFileTicketStore(c)
+	}
+	return nil, fmt.Errorf("tipo de armazenamento de tickets desconhecido: %s", c.Backend)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+type TicketListResponse struct {
+	Tickets []Ticket `json:"tickets"`
+	Total   int      `json:"total"`
+	Limit   int      `json:"limit"`
+	Offset  int      `json:"offset"`
+}
+
+// GET /tickets/{transactionID}
+// Aceita o transactionID retornado pelo /buyTicket ou o id interno do ticket
+// (tickets FAILED não têm transactionID do AirlinesHub).
+func getTicketHandler(w http.ResponseWriter, r *http.Request) {
+	ticket, apiErr := findTicket(r.PathValue("transactionID"))
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ticket)
+}
+
+// Busca o ticket pelo transactionID ou pelo id interno
+func findTicket(value string) (Ticket, *APIError) {
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return Ticket{}, newAPIError(http.StatusBadRequest, fmt.Errorf("transactionID inválido: %w", err))
+	}
+
+	ticket, ok := ticketDB.GetByTransactionID(id)
+	if !ok {
+		ticket, ok = ticketDB.Get(id)
+	}
+	if !ok {
+		return Ticket{}, newAPIError(http.StatusNotFound, fmt.Errorf("ticket %s não encontrado", id))
+	}
+	return ticket, nil
+}
+
+// GET /users/{user}/tickets?status=PAID&from=2025-12-01&to=2025-12-31&limit=20&offset=0
+// from/to filtram pelo dia do voo (inclusive). Os tickets mais recentes vêm primeiro.
+func listUserTicketsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, err := parseQueryInt(query.Get("limit"), defaultPageLimit)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		writeError(w, newAPIError(http.StatusBadRequest, fmt.Errorf("limit deve ser um número entre 1 e %d", maxPageLimit)))
+		return
+	}
+	offset, err := parseQueryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		writeError(w, newAPIError(http.StatusBadRequest, fmt.Errorf("offset deve ser um número maior ou igual a 0")))
+		return
+	}
+
+	from, err := parseQueryDay(query.Get("from"))
+	if err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, fmt.Errorf("from inválido (esperado AAAA-MM-DD): %w", err)))
+		return
+	}
+	to, err := parseQueryDay(query.Get("to"))
+	if err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, fmt.Errorf("to inválido (esperado AAAA-MM-DD): %w", err)))
+		return
+	}
+	status := strings.ToUpper(query.Get("status"))
+
+	tickets := slices.DeleteFunc(ticketDB.ListByUser(r.PathValue("user")), func(t Ticket) bool {
+		if status != "" && t.Status != status {
+			return true
+		}
+		if from.IsZero() && to.IsZero() {
+			return false
+		}
+		day, err := time.Parse(time.DateOnly, t.FlightDay)
+		if err != nil {
+			return true
+		}
+		return (!from.IsZero() && day.Before(from)) || (!to.IsZero() && day.After(to))
+	})
+
+	slices.SortFunc(tickets, func(a, b Ticket) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	page := paginate(tickets, limit, offset)
+
+	writeJSON(w, http.StatusOK, TicketListResponse{
+		Tickets: page,
+		Total:   len(tickets),
+		Limit:   limit,
+		Offset:  offset,
+	})
+}
+
+func parseQueryInt(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// Página de items começando em offset, com até limit itens. Um offset além do fim resulta em página vazia.
+func paginate[T any](items []T, limit, offset int) []T {
+	offset = min(offset, len(items))
+	return items[offset : offset+min(limit, len(items)-offset)]
+}
+
+func parseQueryDay(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
@@ -0,0 +1,75 @@
+// Package deadline propaga o prazo de uma requisição entre os serviços pelo header X-Request-Deadline.
+package deadline
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Header com o tempo restante (em milissegundos) do prazo da requisição original.
+// Os serviços chamados devem abandonar o processamento quando esse tempo acabar.
+const Header = "X-Request-Deadline"
+
+// Maior prazo aceito no header; valores acima dele são reduzidos a Max
+const Max = time.Minute
+
+// Set propaga em req o prazo restante do contexto da requisição, arredondado para cima
+// (um prazo ainda não vencido nunca é enviado como 0).
+func Set(req *http.Request) {
+	deadline, ok := req.Context().Deadline()
+	if !ok {
+		return
+	}
+	remaining := max(time.Until(deadline), 0)
+	millis := (remaining + time.Millisecond - 1) / time.Millisecond
+	req.Header.Set(Header, strconv.FormatInt(int64(max(millis, 1)), 10))
+}
+
+// Parse interpreta o valor do header. Sem o header, ok é false e não há prazo.
+func Parse(r *http.Request) (remaining time.Duration, ok bool, err error) {
+	value := r.Header.Get(Header)
+	if value == "" {
+		return 0, false, nil
+	}
+
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || millis <= 0 {
+		return 0, false, fmt.Errorf("valor inválido no header %s: %q", Header, value)
+	}
+	if millis > Max.Milliseconds() {
+		log.Printf("AVISO: prazo de %dms no header %s acima do máximo, usando %v", millis, Header, Max)
+		return Max, true, nil
+	}
+	return time.Duration(millis) * time.Millisecond, true, nil
+}
+
+// Context retorna o contexto da requisição limitado pelo prazo do header (sem o header vale apenas
+// o cancelamento da conexão). Se o header for inválido, responde 400 e retorna ok=false.
+func Context(w http.ResponseWriter, r *http.Request) (ctx context.Context, cancel context.CancelFunc, ok bool) {
+	remaining, found, err := Parse(r)
+	if err != nil {
+		log.Printf("AVISO: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, nil, false
+	}
+	if !found {
+		ctx, cancel = context.WithCancel(r.Context())
+		return ctx, cancel, true
+	}
+	ctx, cancel = context.WithTimeout(r.Context(), remaining)
+	return ctx, cancel, true
+}
+
+// Exceeded responde 504 e retorna true se o prazo da requisição já acabou
+func Exceeded(ctx context.Context, w http.ResponseWriter) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	log.Printf("[DEADLINE] Prazo da requisição esgotado (%v), abandonando processamento", ctx.Err())
+	http.Error(w, "Prazo da requisição esgotado", http.StatusGatewayTimeout)
+	return true
+}
@@ -0,0 +1,3 @@
+module github.com/fsousabt/shared
+
+go 1.25.3
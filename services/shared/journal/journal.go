@@ -0,0 +1,372 @@
+// Package journal implementa o log append-only com snapshots usado pelos serviços para gravar estado em disco.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type SyncPolicy string
+
+const (
+	SyncAlways   SyncPolicy = "always"   // fsync a cada registro
+	SyncInterval SyncPolicy = "interval" // fsync periódico em segundo plano
+	SyncNever    SyncPolicy = "never"    // deixa a escrita em disco a cargo do sistema operacional
+)
+
+type Config struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+}
+
+// Journal é um log append-only de registros JSON (um por linha) com snapshots.
+// <name>.snapshot guarda o estado compactado e <name>.log os registros escritos depois do snapshot.
+// Na recuperação, o snapshot é lido primeiro e depois o log; um registro incompleto no fim do log
+// (queda no meio de uma escrita) é descartado. Qualquer outro registro inválido interrompe a recuperação,
+// para não descartar os registros válidos gravados depois dele.
+//
+// O snapshot e o log começam com um cabeçalho com a geração do journal, incrementada a cada snapshot.
+// Um log com geração anterior à do snapshot (queda entre a troca do snapshot e o esvaziamento do log)
+// já está contido no snapshot e é descartado em vez de ser reaplicado.
+type Journal struct {
+	mu sync.Mutex
+
+	name         string
+	logPath      string
+	snapshotPath string
+	file         *os.File
+	config       Config
+
+	generation    uint64 // geração do snapshot atual
+	logGeneration uint64 // geração do log atual; diferente de generation, o log precisa ser esvaziado
+
+	records int // registros no log desde o último snapshot
+	dirty   bool
+	done    chan struct{}
+}
+
+func Open(dir string, name string, c Config) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("falha ao criar diretório %s: %w", dir, err)
+	}
+
+	j := &Journal{
+		name:         name,
+		logPath:      filepath.Join(dir, name+".log"),
+		snapshotPath: filepath.Join(dir, name+".snapshot"),
+		config:       c,
+		done:         make(chan struct{}),
+	}
+
+	file, err := os.OpenFile(j.logPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao abrir journal %s: %w", j.logPath, err)
+	}
+	j.file = file
+
+	if c.Sync == SyncInterval {
+		if j.config.SyncInterval <= 0 {
+			j.config.SyncInterval = time.Second
+		}
+		go j.syncLoop()
+	}
+
+	return j, nil
+}
+
+// Cabeçalho gravado na primeira linha do snapshot e do log
+type header struct {
+	Generation *uint64 `json:"journalGeneration"`
+}
+
+func parseHeader(data []byte) (uint64, bool) {
+	var h header
+	if json.Unmarshal(data, &h) != nil || h.Generation == nil {
+		return 0, false
+	}
+	return *h.Generation, true
+}
+
+// Envolve apply para tratar a primeira linha como cabeçalho (se for um), guardando a geração em generation
+func withHeader(generation *uint64, apply func(data []byte) error) func(data []byte) error {
+	first := true
+	return func(data []byte) error {
+		if first {
+			first = false
+			if g, ok := parseHeader(data); ok {
+				*generation = g
+				return nil
+			}
+		}
+		return apply(data)
+	}
+}
+
+// Load entrega a apply cada registro do snapshot e do log, na ordem em que foram escritos
+func (j *Journal) Load(apply func(data []byte) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	snapshot, err := os.Open(j.snapshotPath)
+	if err == nil {
+		_, err = readRecords(snapshot, withHeader(&j.generation, apply))
+		snapshot.Close()
+		if err != nil {
+			return fmt.Errorf("snapshot %s corrompido: %w", j.snapshotPath, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("falha ao abrir snapshot %s: %w", j.snapshotPath, err)
+	}
+
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	j.logGeneration = 0
+	headerRead := false
+	valid, err := readRecords(j.file, withHeader(&j.logGeneration, func(data []byte) error {
+		if !headerRead {
+			headerRead = true
+			if j.logGeneration != j.generation {
+				return errStaleLog
+			}
+		}
+		j.records++
+		return apply(data)
+	}))
+	if errors.Is(err, errStaleLog) {
+		if j.logGeneration > j.generation {
+			return fmt.Errorf("journal %s com geração %d posterior à do snapshot (%d)", j.logPath, j.logGeneration, j.generation)
+		}
+		log.Printf("[Journal] (%s) Log da geração %d já contido no snapshot (geração %d), descartando", j.name, j.logGeneration, j.generation)
+		err = nil
+	}
+	if errors.Is(err, errIncompleteRecord) {
+		log.Printf("[Journal] (%s) Registro incompleto no fim do log, descartando a partir do byte %d", j.name, valid)
+		if err := j.file.Truncate(valid); err != nil {
+			return fmt.Errorf("falha ao truncar journal %s: %w", j.logPath, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("journal %s corrompido no byte %d: %w", j.logPath, valid, err)
+	}
+
+	if j.logGeneration != j.generation {
+		if err := j.resetLog(); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("[Journal] (%s) Recuperado: %d registro(s) no log desde o último snapshot", j.name, j.records)
+	return nil
+}
+
+var (
+	// Última linha do arquivo sem o '\n' final: a escrita foi interrompida por uma queda
+	errIncompleteRecord = errors.New("registro incompleto")
+	// Log de uma geração diferente da do snapshot, interrompe a leitura antes de aplicar registros
+	errStaleLog = errors.New("log de outra geração")
+)
+
+// Lê registros até o fim ou até o primeiro registro incompleto (errIncompleteRecord) ou inválido.
+// Retorna o número de bytes de registros válidos lidos.
+func readRecords(r io.Reader, apply func(data []byte) error) (int64, error) {
+	reader := bufio.NewReader(r)
+	var valid int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				return valid, errIncompleteRecord
+			}
+			return valid, nil
+		}
+		if err != nil {
+			return valid, err
+		}
+
+		data := bytes.TrimSpace(line)
+		if len(data) > 0 {
+			if !json.Valid(data) {
+				return valid, errors.New("registro com JSON inválido")
+			}
+			if err := apply(data); err != nil {
+				return valid, err
+			}
+		}
+		valid += int64(len(line))
+	}
+}
+
+func (j *Journal) Append(record any) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar registro: %w", err)
+	}
+	data = append(data, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// o log ficou de uma geração anterior (falha ao esvaziá-lo no último snapshot): registros gravados
+	// nele seriam descartados na recuperação
+	if j.logGeneration != j.generation {
+		if err := j.resetLog(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := j.file.Write(data); err != nil {
+		return fmt.Errorf("falha ao escrever no journal %s: %w", j.logPath, err)
+	}
+	j.records++
+
+	switch j.config.Sync {
+	case SyncAlways:
+		if err := j.file.Sync(); err != nil {
+			return fmt.Errorf("falha no fsync do journal %s: %w", j.logPath, err)
+		}
+	case SyncInterval:
+		j.dirty = true
+	}
+	return nil
+}
+
+// Records retorna quantos registros foram escritos no log desde o último snapshot
+func (j *Journal) Records() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.records
+}
+
+// Snapshot grava o estado completo (records) e esvazia o log.
+// O snapshot é escrito em um arquivo temporário e renomeado, então uma queda no meio
+// mantém o snapshot anterior e o log intactos. Uma queda depois da troca do snapshot e antes
+// de esvaziar o log deixa um log da geração anterior, descartado na recuperação.
+func (j *Journal) Snapshot(records []any) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	tmpPath := j.snapshotPath + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("falha ao criar snapshot %s: %w", tmpPath, err)
+	}
+
+	generation := j.generation + 1
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	if err := encoder.Encode(header{Generation: &generation}); err != nil {
+		tmp.Close()
+		return fmt.Errorf("falha ao escrever snapshot: %w", err)
+	}
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			tmp.Close()
+			return fmt.Errorf("falha ao escrever snapshot: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("falha ao escrever snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("falha no fsync do snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, j.snapshotPath); err != nil {
+		return fmt.Errorf("falha ao substituir snapshot: %w", err)
+	}
+	syncDir(filepath.Dir(j.snapshotPath))
+	j.generation = generation
+
+	if err := j.resetLog(); err != nil {
+		return err
+	}
+
+	log.Printf("[Journal] (%s) Snapshot gravado com %d registro(s)", j.name, len(records))
+	return nil
+}
+
+// Esvazia o log e grava nele o cabeçalho com a geração do snapshot atual.
+// Deve ser chamado com j.mu travado.
+func (j *Journal) resetLog() error {
+	if err := j.file.Truncate(0); err != nil {
+		return fmt.Errorf("falha ao esvaziar journal %s: %w", j.logPath, err)
+	}
+	j.records = 0
+	j.dirty = false
+
+	data, err := json.Marshal(header{Generation: &j.generation})
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("falha ao escrever no journal %s: %w", j.logPath, err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("falha no fsync do journal %s: %w", j.logPath, err)
+	}
+	j.logGeneration = j.generation
+	return nil
+}
+
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+func (j *Journal) syncLoop() {
+	ticker := time.NewTicker(j.config.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			j.mu.Lock()
+			if j.dirty {
+				if err := j.file.Sync(); err != nil {
+					log.Printf("[Journal] (%s) ERRO: falha no fsync periódico: %v", j.name, err)
+				}
+				j.dirty = false
+			}
+			j.mu.Unlock()
+		case <-j.done:
+			return
+		}
+	}
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	select {
+	case <-j.done:
+		return nil
+	default:
+		close(j.done)
+	}
+
+	if err := j.file.Sync(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
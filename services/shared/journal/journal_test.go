@@ -0,0 +1,190 @@
+package journal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+type record struct {
+	N int `json:"n"`
+}
+
+// Abre o journal e carrega os registros, devolvendo os valores de n na ordem em que foram aplicados
+func openAndLoad(t *testing.T, dir string) (*Journal, []int, error) {
+	t.Helper()
+	j, err := Open(dir, "test", Config{Sync: SyncNever})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { j.Close() })
+
+	var got []int
+	err = j.Load(func(data []byte) error {
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		got = append(got, r.N)
+		return nil
+	})
+	return j, got, err
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot string // conteúdo de test.snapshot; vazio para não criar o arquivo
+		log      string // conteúdo de test.log
+		want     []int
+		wantErr  bool
+	}{
+		{
+			name: "log vazio",
+			want: nil,
+		},
+		{
+			name: "log sem cabeçalho",
+			log:  "{\"n\":1}\n{\"n\":2}\n",
+			want: []int{1, 2},
+		},
+		{
+			name:     "snapshot e log da mesma geração",
+			snapshot: "{\"journalGeneration\":1}\n{\"n\":1}\n{\"n\":2}\n",
+			log:      "{\"journalGeneration\":1}\n{\"n\":3}\n",
+			want:     []int{1, 2, 3},
+		},
+		{
+			name: "registro incompleto no fim é descartado",
+			log:  "{\"n\":1}\n{\"n\":2}\n{\"n\":",
+			want: []int{1, 2},
+		},
+		{
+			name: "linha válida sem o \\n final é descartada",
+			log:  "{\"n\":1}\n{\"n\":2}",
+			want: []int{1},
+		},
+		{
+			name:    "registro inválido no meio interrompe a recuperação",
+			log:     "{\"n\":1}\nlixo\n{\"n\":3}\n",
+			wantErr: true,
+		},
+		{
+			name:     "log de geração anterior já está no snapshot",
+			snapshot: "{\"journalGeneration\":2}\n{\"n\":1}\n{\"n\":2}\n",
+			log:      "{\"journalGeneration\":1}\n{\"n\":2}\n",
+			want:     []int{1, 2},
+		},
+		{
+			name:     "log sem cabeçalho com snapshot de outra geração",
+			snapshot: "{\"journalGeneration\":1}\n{\"n\":1}\n",
+			log:      "{\"n\":1}\n",
+			want:     []int{1},
+		},
+		{
+			name:     "log de geração posterior à do snapshot",
+			snapshot: "{\"journalGeneration\":1}\n{\"n\":1}\n",
+			log:      "{\"journalGeneration\":2}\n{\"n\":2}\n",
+			wantErr:  true,
+		},
+		{
+			name:     "snapshot corrompido",
+			snapshot: "{\"journalGeneration\":1}\nlixo\n",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.snapshot != "" {
+				if err := os.WriteFile(filepath.Join(dir, "test.snapshot"), []byte(tt.snapshot), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(dir, "test.log"), []byte(tt.log), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			j, got, err := openAndLoad(t, dir)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Load = %v, want erro", got)
+				}
+				// o log corrompido não pode ser truncado nem esvaziado
+				if data, _ := os.ReadFile(filepath.Join(dir, "test.log")); string(data) != tt.log {
+					t.Fatalf("log alterado após falha na recuperação: %q", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("Load = %v, want %v", got, tt.want)
+			}
+
+			// um registro gravado depois da recuperação é lido na próxima, depois dos recuperados
+			if err := j.Append(record{N: 100}); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+			j.Close()
+			_, got, err = openAndLoad(t, dir)
+			if err != nil {
+				t.Fatalf("Load após Append: %v", err)
+			}
+			if want := append(slices.Clone(tt.want), 100); !slices.Equal(got, want) {
+				t.Fatalf("Load após Append = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	j, _, err := openAndLoad(t, dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for n := 1; n <= 3; n++ {
+		if err := j.Append(record{N: n}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if j.Records() != 3 {
+		t.Fatalf("Records = %d, want 3", j.Records())
+	}
+
+	// o estado compactado substitui os registros do log
+	if err := j.Snapshot([]any{record{N: 6}}); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if j.Records() != 0 {
+		t.Fatalf("Records após Snapshot = %d, want 0", j.Records())
+	}
+	if err := j.Append(record{N: 4}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := j.Snapshot([]any{record{N: 6}, record{N: 4}}); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if err := j.Append(record{N: 5}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	j.Close()
+
+	j, got, err := openAndLoad(t, dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if want := []int{6, 4, 5}; !slices.Equal(got, want) {
+		t.Fatalf("Load = %v, want %v", got, want)
+	}
+	if j.Records() != 1 {
+		t.Fatalf("Records após recuperação = %d, want 1", j.Records())
+	}
+	if j.generation != 2 || j.logGeneration != 2 {
+		t.Fatalf("geração do snapshot %d e do log %d, want 2", j.generation, j.logGeneration)
+	}
+}